@@ -11,24 +11,57 @@ var remoClient *natureremo.Client
 type ApplianceType string
 
 const (
-	ApplianceTypeLight = "LIGHT"
-	ApplianceTypeTV    = "TV"
-	ApplianceTypeIR    = "IR"
-	ApplianceTypeLocal = "LOCAL"
+	ApplianceTypeLight  = "LIGHT"
+	ApplianceTypeTV     = "TV"
+	ApplianceTypeIR     = "IR"
+	ApplianceTypeLocal  = "LOCAL"
+	ApplianceTypeAirCon = "AC"
 )
 
+// StatusType returns the type reported in appliance statuses, "unknown" for an invalid type
+func (t ApplianceType) StatusType() string {
+	switch t {
+	case ApplianceTypeLight:
+		return "light"
+	case ApplianceTypeTV:
+		return "tv"
+	case ApplianceTypeIR:
+		return "ir"
+	case ApplianceTypeLocal:
+		return "local"
+	case ApplianceTypeAirCon:
+		return "aircon"
+	}
+	return "unknown"
+}
+
 // ApplianceData is ApplianceData
 type ApplianceData struct {
 	ID           string        `yaml:"ID"`
 	Name         string        `yaml:"Name"`
 	Type         ApplianceType `yaml:"Type"`
+	Topic        *string       `yaml:"Topic"` // MQTT topic name used instead of ID
 	SwitchPin    *int          `yaml:"SwitchPin"`
+	Pull         *string       `yaml:"Pull"`     // bias of SwitchPin and ConditionPin, "up", "down" or "none"
+	Debounce     *string       `yaml:"Debounce"` // duration the inputs must be stable, e.g. "20ms"
 	StatusPin    *int          `yaml:"StatusPin"`
+	StatusLED    *StatusLED    `yaml:"StatusLED"`
 	Trigger      Trigger       `yaml:"Trigger"`
 	Timer        *string       `yaml:"Timer"`
 	ConditionPin *int          `yaml:"ConditionPin"`
-	Sender       Sender
-	Display      Display
+	// level of ConditionPin inhibiting presses, "HIGH" (default) or "LOW"
+	ConditionLevel *string `yaml:"ConditionLevel"`
+	// sent instead of the pressed button while inhibited, presses are dropped if nil
+	ConditionButton *string `yaml:"ConditionButton"`
+	// actions of TOGGLE switches by gesture, "PRESS", "LONG", "DOUBLE" or "HOLD"; a press toggles if unset
+	Gestures map[string]Action `yaml:"Gestures"`
+	// Device is the Remo sending the signals, its appliances share a circuit breaker;
+	// the IP of appliances with one, LOCAL or falling back to it, and the Cloud API for others if unset
+	Device *string `yaml:"Device"`
+	// Retry overrides the retry policy of the config for this appliance
+	Retry   *RetryPolicy `yaml:"Retry"`
+	Sender  Sender
+	Display Display
 }
 
 type Sender interface {
@@ -37,6 +70,11 @@ type Sender interface {
 	Send(ctx context.Context, button string) (*natureremo.LightState, error)
 }
 
+// AirConSender is implemented by appliances which accept air conditioner settings
+type AirConSender interface {
+	UpdateSettings(ctx context.Context, settings natureremo.AirConSettings) (*natureremo.AirConSettings, error)
+}
+
 type Display interface {
 	Show()
 	Get() error
@@ -0,0 +1,67 @@
+package controlremo
+
+import (
+	"context"
+
+	"github.com/cormoran/natureremo"
+)
+
+type ApplianceAirCon struct {
+	ApplianceData
+	Temperature   string                     `yaml:"Temperature"`
+	OperationMode natureremo.OperationMode   `yaml:"OperationMode"`
+	AirVolume     natureremo.AirVolume       `yaml:"AirVolume"`
+	AirDirection  natureremo.AirDirection    `yaml:"AirDirection"`
+	Settings      *natureremo.AirConSettings // last settings sent to the aircon
+}
+
+func (a *ApplianceAirCon) On(ctx context.Context) (*natureremo.LightState, error) {
+	_, err := a.UpdateSettings(ctx, natureremo.AirConSettings{Button: natureremo.ButtonPowerOn})
+	return nil, err
+}
+
+func (a *ApplianceAirCon) Off(ctx context.Context) (*natureremo.LightState, error) {
+	_, err := a.UpdateSettings(ctx, natureremo.AirConSettings{Button: natureremo.ButtonPowerOff})
+	return nil, err
+}
+
+func (a *ApplianceAirCon) Send(ctx context.Context, button string) (*natureremo.LightState, error) {
+	_, err := a.UpdateSettings(ctx, natureremo.AirConSettings{Button: natureremo.Button(button)})
+	return nil, err
+}
+
+// UpdateSettings sends settings merged over the last sent ones; empty fields keep their current value
+func (a *ApplianceAirCon) UpdateSettings(ctx context.Context, settings natureremo.AirConSettings) (*natureremo.AirConSettings, error) {
+	s := a.current()
+	if settings.Temperature != "" {
+		s.Temperature = settings.Temperature
+	}
+	if settings.OperationMode != "" {
+		s.OperationMode = settings.OperationMode
+	}
+	if settings.AirVolume != "" {
+		s.AirVolume = settings.AirVolume
+	}
+	if settings.AirDirection != "" {
+		s.AirDirection = settings.AirDirection
+	}
+	s.Button = settings.Button
+	err := remoClient.ApplianceService.UpdateAirConSettings(ctx, &natureremo.Appliance{ID: a.ID}, &s)
+	if err != nil {
+		return nil, err
+	}
+	a.Settings = &s
+	return &s, nil
+}
+
+func (a *ApplianceAirCon) current() natureremo.AirConSettings {
+	if a.Settings != nil {
+		return *a.Settings
+	}
+	return natureremo.AirConSettings{
+		Temperature:   a.Temperature,
+		OperationMode: a.OperationMode,
+		AirVolume:     a.AirVolume,
+		AirDirection:  a.AirDirection,
+	}
+}
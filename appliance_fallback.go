@@ -0,0 +1,147 @@
+package controlremo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cormoran/natureremo"
+)
+
+// Values of Prefer, the API an appliance with both cloud and local signals tries first
+const (
+	PreferCloud = "cloud"
+	PreferLocal = "local"
+)
+
+// errNoLocalSignal is returned for buttons without a local signal
+var errNoLocalSignal = errors.New("no local signal for button")
+
+// ApplianceFallback sends with the preferred of the Cloud API and the local API of the Remo,
+// falling back to the other while the preferred one is unreachable or rate limited
+type ApplianceFallback struct {
+	Cloud  Sender
+	Local  ApplianceLocal
+	Prefer string
+}
+
+// newFallback wraps the cloud sender of an appliance declaring local signals too
+func newFallback(cloud Sender, local ApplianceLocal, prefer string) Sender {
+	f := ApplianceFallback{Cloud: cloud, Local: local, Prefer: prefer}
+	if _, ok := cloud.(AirConSender); ok {
+		return AirConFallback{f}
+	}
+	return f
+}
+
+func (a ApplianceFallback) On(ctx context.Context) (*natureremo.LightState, error) {
+	return a.send(ctx, a.Cloud.On, a.Local.On)
+}
+
+func (a ApplianceFallback) Off(ctx context.Context) (*natureremo.LightState, error) {
+	return a.send(ctx, a.Cloud.Off, a.Local.Off)
+}
+
+// Send falls back for "on" and "off" only, the local signals of other buttons are unknown
+func (a ApplianceFallback) Send(ctx context.Context, button string) (*natureremo.LightState, error) {
+	cloud := func(ctx context.Context) (*natureremo.LightState, error) {
+		return a.Cloud.Send(ctx, button)
+	}
+	switch button {
+	case "on":
+		return a.send(ctx, cloud, a.Local.On)
+	case "off":
+		return a.send(ctx, cloud, a.Local.Off)
+	default:
+		return a.send(ctx, cloud, func(context.Context) (*natureremo.LightState, error) {
+			return nil, fmt.Errorf("%w %s", errNoLocalSignal, button)
+		})
+	}
+}
+
+func (a ApplianceFallback) send(ctx context.Context, cloud, local func(context.Context) (*natureremo.LightState, error)) (*natureremo.LightState, error) {
+	return fallBack(ctx, a.Local.ID, a.Prefer, cloud, local)
+}
+
+// fallBack calls the preferred of cloud and local, then the other one if the preferred is unreachable or rate limited
+func fallBack[T any](ctx context.Context, id, prefer string, cloud, local func(context.Context) (T, error)) (T, error) {
+	first, second := cloud, local
+	firstName, secondName := PreferCloud, PreferLocal
+	if prefer == PreferLocal {
+		first, second = local, cloud
+		firstName, secondName = PreferLocal, PreferCloud
+	}
+	var err error
+	if firstName == PreferCloud && cloudLimited() {
+		err = fmt.Errorf("rate limit of the Cloud API exhausted")
+	} else {
+		var v T
+		v, err = first(ctx)
+		if err == nil || !unreachable(err) || ctx.Err() != nil {
+			return v, err
+		}
+	}
+	log.Printf("Sending to %s with the %s API instead of the %s API: %v", id, secondName, firstName, err)
+	v, fallbackErr := second(ctx)
+	if fallbackErr != nil {
+		return v, fmt.Errorf("%v, fallback to the %s API failed: %w", err, secondName, fallbackErr)
+	}
+	return v, nil
+}
+
+// unreachable reports whether an API failed with err because it could not be reached or is rate limited
+func unreachable(err error) bool {
+	var apiErr *natureremo.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatus == http.StatusTooManyRequests {
+		return true
+	}
+	return Transient(err)
+}
+
+// cloudLimited reports whether the Cloud API refuses requests until its rate limit resets
+func cloudLimited() bool {
+	if remoClient == nil {
+		return false
+	}
+	rl := remoClient.LastRateLimit
+	return rl != nil && rl.Limit > 0 && rl.Remaining <= 0 && time.Now().Before(rl.Reset)
+}
+
+// AirConFallback is an ApplianceFallback of an air conditioner, powering it on and off with the local signals
+// while the Cloud API is unreachable
+type AirConFallback struct {
+	ApplianceFallback
+}
+
+// UpdateSettings falls back for power buttons only, the local signals carry no settings
+func (a AirConFallback) UpdateSettings(ctx context.Context, settings natureremo.AirConSettings) (*natureremo.AirConSettings, error) {
+	cloud := func(ctx context.Context) (*natureremo.AirConSettings, error) {
+		return a.Cloud.(AirConSender).UpdateSettings(ctx, settings)
+	}
+	local := func(ctx context.Context) (*natureremo.AirConSettings, error) {
+		var err error
+		switch {
+		case settings.Button == natureremo.ButtonPowerOff:
+			_, err = a.Local.Off(ctx)
+		case settings.Button == natureremo.ButtonPowerOn && settings.Temperature == "" &&
+			settings.OperationMode == "" && settings.AirVolume == "" && settings.AirDirection == "":
+			_, err = a.Local.On(ctx)
+		default:
+			err = errNoLocalSignal
+		}
+		if err != nil {
+			return nil, err
+		}
+		s := settings
+		if ac, ok := a.Cloud.(*ApplianceAirCon); ok {
+			s = ac.current()
+			s.Button = settings.Button
+			ac.Settings = &s
+		}
+		return &s, nil
+	}
+	return fallBack(ctx, a.Local.ID, a.Prefer, cloud, local)
+}
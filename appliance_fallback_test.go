@@ -0,0 +1,96 @@
+package controlremo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cormoran/natureremo"
+)
+
+// cloudSender fails every send with err, counting them
+type cloudSender struct {
+	err   error
+	calls *int
+}
+
+func (s cloudSender) On(ctx context.Context) (*natureremo.LightState, error) {
+	return s.Send(ctx, "on")
+}
+
+func (s cloudSender) Off(ctx context.Context) (*natureremo.LightState, error) {
+	return s.Send(ctx, "off")
+}
+
+func (s cloudSender) Send(ctx context.Context, button string) (*natureremo.LightState, error) {
+	*s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &natureremo.LightState{Power: button}, nil
+}
+
+func TestApplianceFallback(t *testing.T) {
+	var emitted atomic.Int32
+	remo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		emitted.Add(1)
+	}))
+	defer remo.Close()
+	ip := strings.TrimPrefix(remo.URL, "http://")
+
+	tests := []struct {
+		name       string
+		prefer     string
+		cloudErr   error
+		localIP    string
+		wantCloud  int
+		wantLocal  int32
+		wantErr    bool
+		wantResult bool // the state reported by the cloud
+	}{
+		{"cloud", "", nil, ip, 1, 0, false, true},
+		{"cloud down", "", &natureremo.APIError{HTTPStatus: 503}, ip, 1, 1, false, false},
+		{"rate limited", "", &natureremo.APIError{HTTPStatus: 429}, ip, 1, 1, false, false},
+		{"rejected", "", &natureremo.APIError{HTTPStatus: 400}, ip, 1, 0, true, false},
+		{"local", PreferLocal, nil, ip, 0, 1, false, false},
+		{"local down", PreferLocal, nil, "127.0.0.1:1", 1, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emitted.Store(0)
+			calls := 0
+			local := ApplianceLocal{
+				ApplianceData: ApplianceData{ID: "light"},
+				IP:            tt.localIP,
+				OnLocal:       natureremo.IRSignal{Freq: 38, Data: []int64{1, 2}, Format: "us"},
+			}
+			s := newFallback(cloudSender{err: tt.cloudErr, calls: &calls}, local, tt.prefer)
+			state, err := s.On(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if calls != tt.wantCloud {
+				t.Errorf("cloud sends = %d, want %d", calls, tt.wantCloud)
+			}
+			if got := emitted.Load(); got != tt.wantLocal {
+				t.Errorf("local sends = %d, want %d", got, tt.wantLocal)
+			}
+			if (state != nil) != tt.wantResult {
+				t.Errorf("state = %v, want a state %t", state, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestApplianceFallbackKeepsAirConSettings(t *testing.T) {
+	calls := 0
+	if _, ok := newFallback(cloudSender{calls: &calls}, ApplianceLocal{}, "").(AirConSender); ok {
+		t.Error("fallback of a light accepts aircon settings")
+	}
+	if _, ok := newFallback(&ApplianceAirCon{}, ApplianceLocal{}, "").(AirConSender); !ok {
+		t.Error("fallback of an aircon does not accept aircon settings")
+	}
+}
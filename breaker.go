@@ -0,0 +1,258 @@
+package controlremo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CloudDevice is the breaker key of appliances sent through the Remo Cloud API without a configured Device
+const CloudDevice = "cloud"
+
+// Defaults of BreakerConfig
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrBreakerOpen is returned for sends to a device whose circuit breaker is open
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of the circuit breaker of a Remo device
+type BreakerState int
+
+const (
+	// BreakerClosed lets every send pass
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen lets a single trial send pass after the cooldown
+	BreakerHalfOpen
+	// BreakerOpen fails sends right away
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig tunes the circuit breakers of the Remo devices
+type BreakerConfig struct {
+	// Threshold is the number of transient failures in a row tripping the breaker
+	Threshold int `yaml:"Threshold"`
+	// Cooldown is how long a tripped breaker fails sends before letting a trial pass
+	Cooldown time.Duration `yaml:"Cooldown"`
+}
+
+var (
+	breakerStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("remo", "breaker", "state"),
+		"The state of the circuit breaker of a remo device, 0 closed, 1 half-open and 2 open",
+		[]string{"device"}, nil,
+	)
+
+	breakerTripsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("remo", "breaker", "trips_total"),
+		"The total number of times the circuit breaker of a remo device opened",
+		[]string{"device"}, nil,
+	)
+
+	sendRetriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("remo", "send", "retries_total"),
+		"The total number of sends retried after a transient failure",
+		nil, nil,
+	)
+)
+
+// Breakers holds a circuit breaker per Remo device so that a device which keeps failing
+// fails presses right away instead of retrying every one of them
+type Breakers struct {
+	config BreakerConfig
+	// OnChange is called with every change of the state of a breaker; set it before the first send
+	OnChange func(device string, state BreakerState)
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	retries  int
+}
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool // the trial send of a half-open breaker is in flight
+	trips    int
+}
+
+// NewBreakers returns closed breakers tuned by config
+func NewBreakers(config BreakerConfig) *Breakers {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultBreakerThreshold
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultBreakerCooldown
+	}
+	return &Breakers{config: config, breakers: make(map[string]*breaker)}
+}
+
+// State returns the state of the breaker of a device
+func (b *Breakers) State(device string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if br, ok := b.breakers[device]; ok {
+		return br.state
+	}
+	return BreakerClosed
+}
+
+func (b *Breakers) get(device string) *breaker {
+	br, ok := b.breakers[device]
+	if !ok {
+		br = &breaker{}
+		b.breakers[device] = br
+	}
+	return br
+}
+
+// allow returns ErrBreakerOpen unless a send to the device may pass
+func (b *Breakers) allow(device string) error {
+	b.mu.Lock()
+	br := b.get(device)
+	changed := false
+	switch br.state {
+	case BreakerOpen:
+		if time.Since(br.openedAt) < b.config.Cooldown {
+			b.mu.Unlock()
+			return fmt.Errorf("%w for %s", ErrBreakerOpen, device)
+		}
+		br.state = BreakerHalfOpen
+		br.trial = true
+		changed = true
+	case BreakerHalfOpen:
+		if br.trial {
+			b.mu.Unlock()
+			return fmt.Errorf("%w for %s", ErrBreakerOpen, device)
+		}
+		br.trial = true
+	}
+	b.mu.Unlock()
+	if changed {
+		b.changed(device, BreakerHalfOpen)
+	}
+	return nil
+}
+
+// record updates the breaker of a device with the outcome of a send; the device answered unless
+// the failure was transient, a cancelled send tells nothing
+func (b *Breakers) record(device string, err error) {
+	b.mu.Lock()
+	br := b.get(device)
+	before := br.state
+	br.trial = false
+	switch {
+	case errors.Is(err, context.Canceled):
+	case !Transient(err):
+		br.state = BreakerClosed
+		br.failures = 0
+	default:
+		br.failures++
+		if br.state == BreakerHalfOpen || br.failures >= b.config.Threshold {
+			if br.state != BreakerOpen {
+				br.trips++
+			}
+			br.state = BreakerOpen
+			br.openedAt = time.Now()
+		}
+	}
+	after := br.state
+	b.mu.Unlock()
+	if after != before {
+		b.changed(device, after)
+	}
+}
+
+func (b *Breakers) changed(device string, state BreakerState) {
+	log.Printf("Circuit breaker of %s is %s", device, state)
+	if b.OnChange != nil {
+		b.OnChange(device, state)
+	}
+}
+
+// Send calls fn for the appliance, retrying transient failures by the retry policy of the appliance,
+// unless the breaker of its device is open; with nil breakers sends are retried only
+func Send[T any](ctx context.Context, b *Breakers, a ApplianceData, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	policy := RetryPolicy{}
+	if a.Retry != nil {
+		policy = *a.Retry
+	}
+	policy = policy.withDefaults()
+	device := a.BreakerDevice()
+	for attempt := 1; ; attempt++ {
+		if b != nil {
+			if err := b.allow(device); err != nil {
+				return zero, err
+			}
+		}
+		v, err := fn(ctx)
+		if b != nil {
+			b.record(device, err)
+		}
+		if err == nil || attempt >= policy.Attempts || !Transient(err) || ctx.Err() != nil {
+			return v, err
+		}
+		log.Printf("Attempt %d of %d to send to %s failed, retrying: %v", attempt, policy.Attempts, a.ID, err)
+		if b != nil {
+			b.mu.Lock()
+			b.retries++
+			b.mu.Unlock()
+		}
+		if !sleep(ctx, policy.delay(attempt)) {
+			return zero, err
+		}
+	}
+}
+
+// SendButton is Send for a button of the appliance, retried only if the button is idempotent
+func SendButton[T any](ctx context.Context, b *Breakers, a ApplianceData, button string, fn func(context.Context) (T, error)) (T, error) {
+	if !Idempotent(a, button) {
+		a.Retry = &RetryPolicy{Attempts: 1}
+	}
+	return Send(ctx, b, a, fn)
+}
+
+// BreakerDevice returns the Remo device whose circuit breaker guards the appliance
+func (a ApplianceData) BreakerDevice() string {
+	if a.Device != nil && *a.Device != "" {
+		return *a.Device
+	}
+	return CloudDevice
+}
+
+// Describe is to describe the metrics for Prometheus
+func (b *Breakers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerStateDesc
+	ch <- breakerTripsDesc
+	ch <- sendRetriesDesc
+}
+
+// Collect reports the state and trips of every breaker and the retried sends
+func (b *Breakers) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for device, br := range b.breakers {
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, float64(br.state), device)
+		ch <- prometheus.MustNewConstMetric(breakerTripsDesc, prometheus.CounterValue, float64(br.trips), device)
+	}
+	ch <- prometheus.MustNewConstMetric(sendRetriesDesc, prometheus.CounterValue, float64(b.retries))
+}
@@ -0,0 +1,146 @@
+package controlremo
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cormoran/natureremo"
+)
+
+var errTimeout error = &url.Error{Op: "Post", URL: "https://api.nature.global/1/signals", Err: context.DeadlineExceeded}
+
+func testAppliance(attempts int) ApplianceData {
+	return ApplianceData{ID: "light", Retry: &RetryPolicy{Attempts: attempts, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}}
+}
+
+// failing returns a send failing with the errors in turn and succeeding afterwards, counting the calls
+func failing(calls *int, errs ...error) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		*calls++
+		if *calls <= len(errs) {
+			return "", errs[*calls-1]
+		}
+		return "ok", nil
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", 3, nil, 1, false},
+		{"transient", 3, []error{errTimeout, &natureremo.APIError{HTTPStatus: 503}}, 3, false},
+		{"exhausted", 3, []error{errTimeout, errTimeout, errTimeout}, 3, true},
+		{"rejected", 3, []error{&natureremo.APIError{HTTPStatus: 400}}, 1, true},
+		{"unknown", 3, []error{errors.New("invalid response")}, 1, true},
+		{"disabled", 1, []error{errTimeout}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			v, err := Send(context.Background(), nil, testAppliance(tt.attempts), failing(&calls, tt.errs...))
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && v != "ok" {
+				t.Errorf("v = %q, want ok", v)
+			}
+		})
+	}
+}
+
+func TestSendButtonRetriesIdempotentOnly(t *testing.T) {
+	tests := []struct {
+		typ       ApplianceType
+		button    string
+		wantCalls int
+	}{
+		{ApplianceTypeLight, "on", 2},
+		{ApplianceTypeLight, "toggle", 1},
+		{ApplianceTypeAirCon, "off", 2},
+		{ApplianceTypeIR, "on", 1},
+		{ApplianceTypeTV, "power", 1},
+	}
+	for _, tt := range tests {
+		a := testAppliance(3)
+		a.Type = tt.typ
+		calls := 0
+		SendButton(context.Background(), nil, a, tt.button, failing(&calls, errTimeout))
+		if calls != tt.wantCalls {
+			t.Errorf("%s %s: calls = %d, want %d", tt.typ, tt.button, calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := NewBreakers(BreakerConfig{Threshold: 2, Cooldown: 20 * time.Millisecond})
+	var changes []BreakerState
+	b.OnChange = func(device string, state BreakerState) {
+		if device != CloudDevice {
+			t.Errorf("device = %s, want %s", device, CloudDevice)
+		}
+		changes = append(changes, state)
+	}
+	a := testAppliance(1)
+	ctx := context.Background()
+
+	calls := 0
+	for range 2 {
+		Send(ctx, b, a, failing(&calls, errTimeout, errTimeout))
+	}
+	if got := b.State(CloudDevice); got != BreakerOpen {
+		t.Fatalf("state after failures = %s, want open", got)
+	}
+	if _, err := Send(ctx, b, a, failing(&calls)); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("err while open = %v, want ErrBreakerOpen", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, open breaker must not send", calls)
+	}
+
+	// A failed trial opens the breaker again
+	time.Sleep(30 * time.Millisecond)
+	calls = 0
+	Send(ctx, b, a, failing(&calls, errTimeout))
+	if got := b.State(CloudDevice); got != BreakerOpen {
+		t.Fatalf("state after failed trial = %s, want open", got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := Send(ctx, b, a, failing(&calls)); err != nil {
+		t.Fatalf("trial failed: %v", err)
+	}
+	if got := b.State(CloudDevice); got != BreakerClosed {
+		t.Fatalf("state after trial = %s, want closed", got)
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("changes = %v, want %v", changes, want)
+			break
+		}
+	}
+}
+
+func TestBreakerIgnoresRejections(t *testing.T) {
+	b := NewBreakers(BreakerConfig{Threshold: 1})
+	calls := 0
+	Send(context.Background(), b, testAppliance(1), failing(&calls, &natureremo.APIError{HTTPStatus: 404}))
+	if got := b.State(CloudDevice); got != BreakerClosed {
+		t.Errorf("state = %s, a rejected request must not trip the breaker", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/mqtt"
+)
+
+// applianceResponse is an appliance as returned by the REST API
+type applianceResponse struct {
+	ID      string              `json:"id"`
+	Name    string              `json:"name"`
+	Type    pi.ApplianceType    `json:"type"`
+	Trigger pi.Trigger          `json:"trigger,omitempty"`
+	Status  *pi.ApplianceStatus `json:"status,omitempty"`
+}
+
+// errorResponse is the body of failed REST API requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// registerAPI adds the REST API handlers to mux
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/appliances", listAppliances)
+	mux.HandleFunc("GET /api/appliances/{id}", getAppliance)
+	mux.HandleFunc("POST /api/appliances/{id}/command", commandAppliance)
+	mux.HandleFunc("GET /api/history", listHistory)
+}
+
+// listAppliances returns every configured appliance with its last known status
+func listAppliances(w http.ResponseWriter, r *http.Request) {
+	appliances := make([]applianceResponse, 0, len(config.Appliances))
+	for id, a := range config.Appliances {
+		appliances = append(appliances, newApplianceResponse(id, a))
+	}
+	sort.Slice(appliances, func(i, j int) bool {
+		return appliances[i].ID < appliances[j].ID
+	})
+	writeJSON(w, http.StatusOK, appliances)
+}
+
+// getAppliance returns a single appliance with its last known status
+func getAppliance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a, ok := config.Appliances[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("appliance not found: %s", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, newApplianceResponse(id, a))
+}
+
+// commandAppliance executes a command with the same semantics as MQTT commands and returns the resulting status
+func commandAppliance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a, ok := config.Appliances[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("appliance not found: %s", id))
+		return
+	}
+
+	var cmd mqtt.Command
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid command: %v", err))
+		return
+	}
+	cmd.ApplianceID = id
+	if cmd.Button == "" && a.Type != pi.ApplianceTypeAirCon {
+		writeError(w, http.StatusBadRequest, errors.New("button is required"))
+		return
+	}
+
+	err := handleCommand(r.Context(), cmd)
+	recordCommand(cmd, err)
+	if err != nil {
+		log.Printf("Failed to handle API command for %s: %v", id, err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newApplianceResponse(id, a))
+}
+
+// listHistory returns the recent commands, oldest first
+func listHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, store.History())
+}
+
+func newApplianceResponse(id string, a pi.ApplianceData) applianceResponse {
+	resp := applianceResponse{
+		ID:      id,
+		Name:    a.Name,
+		Type:    a.Type,
+		Trigger: a.Trigger,
+	}
+	if status, ok := states.Get(id); ok {
+		resp.Status = &status
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
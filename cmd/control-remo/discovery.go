@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cormoran/natureremo"
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/metrics"
+	"github.com/eivy/control-remo-from-pi/mqtt"
+)
+
+// sensorUnits maps Sensor payload keys to their Home Assistant units
+var sensorUnits = map[string]string{
+	"temperature":  "°C",
+	"humidity":     "%",
+	"illumination": "lx",
+}
+
+// publishDiscovery announces configured appliances and Remo sensors to Home Assistant
+func publishDiscovery(ctx context.Context, client *natureremo.Client) error {
+	if mqttClient == nil {
+		return nil
+	}
+	prefix := os.Getenv("HA_DISCOVERY_PREFIX")
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+
+	var entities []mqtt.Entity
+	for id, a := range config.Appliances {
+		e := mqtt.Entity{ID: id, Name: a.Name}
+		switch a.Type {
+		case pi.ApplianceTypeLight:
+			e.Component = mqtt.ComponentLight
+		case pi.ApplianceTypeAirCon:
+			e.Component = mqtt.ComponentClimate
+		default:
+			e.Component = mqtt.ComponentSwitch
+		}
+		entities = append(entities, e)
+	}
+
+	devices, err := pi.Schedule(ctx, scheduler, pi.PriorityBackground, metrics.RequestKeyDevices, client.DeviceService.GetAll)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		for _, sensor := range sensorKeys(d) {
+			entities = append(entities, mqtt.Entity{
+				ID:        d.ID,
+				Name:      d.Name,
+				Component: mqtt.ComponentSensor,
+				Sensor:    sensor,
+				Unit:      sensorUnits[sensor],
+			})
+		}
+	}
+
+	return mqttClient.PublishDiscovery(prefix, entities)
+}
+
+// sensorKeys returns the Sensor payload keys the device reports values for
+func sensorKeys(d *natureremo.Device) []string {
+	var keys []string
+	if _, ok := d.NewestEvents[natureremo.SensorTypeTemperature]; ok {
+		keys = append(keys, "temperature")
+	}
+	if _, ok := d.NewestEvents[natureremo.SensorTypeHumidity]; ok {
+		keys = append(keys, "humidity")
+	}
+	if _, ok := d.NewestEvents[natureremo.SensorTypeIllumination]; ok {
+		keys = append(keys, "illumination")
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sensorFromDevice returns the latest sensor values of the device
+func sensorFromDevice(d *natureremo.Device) mqtt.Sensor {
+	s := mqtt.Sensor{DeviceID: d.ID, DeviceName: d.Name, Timestamp: time.Now()}
+	if v, ok := d.NewestEvents[natureremo.SensorTypeTemperature]; ok {
+		s.Temperature = &v.Value
+	}
+	if v, ok := d.NewestEvents[natureremo.SensorTypeHumidity]; ok {
+		s.Humidity = &v.Value
+	}
+	if v, ok := d.NewestEvents[natureremo.SensorTypeIllumination]; ok {
+		s.Illumination = &v.Value
+	}
+	return s
+}
@@ -18,9 +18,13 @@ import (
 )
 
 var config pi.Config
-var timer = make(map[string]*time.Timer)
 var mqttClient *mqtt.Client
-var lastKnownStates = make(map[string]*ApplianceStatus)
+var store *pi.Store
+var states *pi.StateManager
+var scheduler *pi.Scheduler
+var breakers *pi.Breakers
+var collector *metrics.Collector
+var statusSyncer *statusSync
 
 func main() {
 	var err error
@@ -35,35 +39,50 @@ func main() {
 		log.Fatal("REMO_SECRET environment variable is required")
 	}
 	remoClient := natureremo.NewClient(remoSecret)
+	// Every request to the Remo API is counted and logged, whoever makes it
+	collector = metrics.NewCollector(remoClient, config.CheckInterval)
 	pi.SetRemoClient(remoClient)
+	// Every request to the Cloud API shares the rate limit through the scheduler
+	scheduler = pi.NewScheduler(remoClient)
+	// Devices failing every send fail presses right away until they recover
+	breakers = pi.NewBreakers(config.Breaker)
+	breakers.OnChange = publishBreaker
 
-	// Initialize MQTT client if broker is configured
-	mqttBroker := os.Getenv("MQTT_BROKER")
-	if mqttBroker == "" {
-		log.Fatal("set MQTT_BROKER")
-	}
-	mqttPortStr := os.Getenv("MQTT_PORT")
-	if mqttPortStr == "" {
-		mqttPortStr = "1883"
-	}
-	mqttPort, err := strconv.Atoi(mqttPortStr)
+	store, err = pi.OpenStore(config.StateFile)
 	if err != nil {
-		log.Fatalf("Invalid MQTT_PORT: %v", err)
+		log.Fatalf("Failed to open state file: %v", err)
 	}
+	states = pi.NewStateManager(store)
+	states.Subscribe(func(c pi.StateChange) {
+		collector.UpdateApplianceState(c.Status.ID, c.Status.Name, c.Status.Type, c.Status.PowerOn)
+	})
+	// Publish the changes made by this daemon, those received on MQTT are published already
+	states.Subscribe(func(c pi.StateChange) {
+		if !c.External {
+			publishApplianceStatusChange(&c.Status)
+		}
+	})
 
-	mqttConfig := mqtt.Config{
-		Broker:   mqttBroker,
-		Port:     mqttPort,
-		Username: os.Getenv("MQTT_USERNAME"),
-		Password: os.Getenv("MQTT_PASSWORD"),
-		ClientID: os.Getenv("MQTT_CLIENT_ID"),
+	// Initialize MQTT client from MQTT_* environment variables
+	mqttConfig, err := mqtt.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
-
 	if mqttConfig.ClientID == "" {
 		mqttConfig.ClientID = "remo-controller"
 	}
+	// Let subscribers know whether control-remo is alive
+	mqttConfig.Availability = true
 
-	mqttClient = mqtt.NewClient(mqttConfig)
+	mqttConfig.Topics = config.Topics()
+	// A broker which restarted may have lost the retained states
+	statusSyncer = newStatusSync(config.StatusSync)
+	mqttConfig.OnConnect = statusSyncer.Trigger
+
+	mqttClient, err = mqtt.NewClient(mqttConfig)
+	if err != nil {
+		log.Fatalf("Failed to create MQTT client: %v", err)
+	}
 	if err := mqttClient.Connect(); err != nil {
 		log.Printf("Failed to connect to MQTT broker: %v", err)
 		mqttClient = nil
@@ -71,60 +90,101 @@ func main() {
 		log.Printf("MQTT client initialized successfully")
 	}
 	ctx := context.Background()
+	go scheduler.Run(ctx)
 
 	// Start MQTT command subscription if client is available
-	if err := mqttClient.SubscribeCommands(ctx, &MQTTCommandHandler{}); err != nil {
-		log.Printf("Failed to subscribe to MQTT commands: %v", err)
+	if mqttClient != nil {
+		if err := mqttClient.SubscribeCommands(ctx, &MQTTCommandHandler{}); err != nil {
+			log.Printf("Failed to subscribe to MQTT commands: %v", err)
+		}
+		if err := mqttClient.SubscribeStatus(ctx, &MQTTStatusHandler{}); err != nil {
+			log.Printf("Failed to subscribe to MQTT statuses: %v", err)
+		}
+		go statusSyncer.Run(ctx, mqttClient)
 	}
-	// Start MQTT command subscription if client is available
-	if err := mqttClient.SubscribeStatus(ctx, &MQTTStatusHandler{}); err != nil {
-		log.Printf("Failed to subscribe to MQTT commands: %v", err)
+
+	// Timers which ran out while stopped turn their appliances off now
+	restoreTimers()
+
+	if err := publishDiscovery(ctx, remoClient); err != nil {
+		log.Printf("Failed to publish Home Assistant discovery: %v", err)
 	}
 
-	mqttClient.StartStatusPublisher(ctx)
+	// Reflect changes made outside of this daemon
+	if config.CheckInterval > 0 {
+		go reconcile(ctx, remoClient, config.CheckInterval)
+	}
 
 	metricsPath := "/metrics"
 	baseURL := "https://api.nature.global"
 	cacheInvalidationSeconds := 60
+	tariffs := make([]metrics.Tariff, 0, len(config.Tariffs))
+	for _, t := range config.Tariffs {
+		tariffs = append(tariffs, metrics.Tariff(t))
+	}
 	c := &metrics.Config{
 		APIBaseURL:               baseURL,
 		MetricsPath:              metricsPath,
 		OAuthToken:               os.Getenv("REMO_SECRET"),
 		ListenPort:               config.Server.Port,
 		CacheInvalidationSeconds: cacheInvalidationSeconds,
+		Scheduler:                scheduler,
+		Tariffs:                  tariffs,
+		OnEnergySummary:          publishEnergySummary,
 	}
 
-	e, err := metrics.NewExporter(c, remoClient, mqttClient)
+	e, err := metrics.NewExporter(c, remoClient)
 	if err != nil {
 		log.Fatalf("Failed to create exporter: %v", err)
 	}
 
+	go e.Run(ctx)
+
 	prometheus.MustRegister(e)
+	prometheus.MustRegister(scheduler)
+	prometheus.MustRegister(breakers)
+	prometheus.MustRegister(collector)
 
 	http.Handle(c.MetricsPath, promhttp.Handler())
+	registerAPI(http.DefaultServeMux)
 	http.ListenAndServe(fmt.Sprintf("0.0.0.0:%s", config.Server.Port), nil)
 }
 
 type MQTTStatusHandler struct{}
 
 func (h *MQTTStatusHandler) HandleStatus(sts mqtt.Status) error {
-	lastKnownStates[sts.ApplianceID] = &ApplianceStatus{
-		ID:        sts.ApplianceID,
-		Name:      sts.ApplianceName,
-		PowerOn:   sts.PowerState,
-		Type:      sts.Type,
-		Available: true,
-	}
+	states.SetExternal(pi.ApplianceStatus{
+		ID:         sts.ApplianceID,
+		Name:       sts.ApplianceName,
+		PowerOn:    sts.PowerState,
+		Type:       sts.Type,
+		Available:  true,
+		AirCon:     sts.Settings,
+		Brightness: sts.Brightness,
+	})
 	return nil
 }
 
 // MQTTCommandHandler handles MQTT commands
 type MQTTCommandHandler struct{}
 
-// HandleCommand processes MQTT commands for appliance control
-func (h *MQTTCommandHandler) HandleCommand(cmd mqtt.Command) error {
-	ctx := context.Background()
+// HandleCommand processes MQTT commands for appliance control and returns the resulting status
+func (h *MQTTCommandHandler) HandleCommand(cmd mqtt.Command) (*mqtt.Status, error) {
+	err := handleCommand(context.Background(), cmd)
+	recordCommand(cmd, err)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := states.Get(cmd.ApplianceID)
+	if !ok {
+		return nil, nil
+	}
+	s := newMQTTStatus(&status)
+	return &s, nil
+}
 
+// handleCommand executes a command on the appliance it addresses
+func handleCommand(ctx context.Context, cmd mqtt.Command) error {
 	// Find the appliance by ID
 	appliance, exists := config.Appliances[cmd.ApplianceID]
 	if !exists {
@@ -138,7 +198,7 @@ func (h *MQTTCommandHandler) HandleCommand(cmd mqtt.Command) error {
 			return err
 		}
 
-		if timer[appliance.ID] == nil {
+		if !states.TimerRunning(appliance.ID) {
 			fmt.Println("TIMER", appliance.Name, "Start")
 			// Execute ON command and publish status
 			if err := executeApplianceCommandAndPublishStatus(ctx, appliance, "on"); err != nil {
@@ -147,34 +207,36 @@ func (h *MQTTCommandHandler) HandleCommand(cmd mqtt.Command) error {
 			}
 
 			// Set timer to turn off later
-			timer[appliance.ID] = time.AfterFunc(d, func() {
-				fmt.Println("TIMER", appliance.Name, "End")
-				executeApplianceCommandAndPublishStatus(context.Background(), appliance, "off")
-				timer[appliance.ID] = nil
-			})
+			startTimer(appliance, d)
 		} else {
 			fmt.Println("TIMER", appliance.Name, "Restart")
-			timer[appliance.ID].Reset(d)
+			startTimer(appliance, d)
 		}
 		return nil
+	} else if appliance.Type == pi.ApplianceTypeAirCon {
+		// Air conditioners take their settings from the command as well as the button
+		return executeAirConCommandAndPublishStatus(ctx, appliance, cmd)
 	} else {
 		// Execute the command and publish status based on actual API response
 		return executeApplianceCommandAndPublishStatus(ctx, appliance, cmd.Button)
 	}
 }
 
-// ApplianceStatus represents the current status of an appliance
-type ApplianceStatus struct {
-	ID        string
-	Name      string
-	Type      string
-	PowerOn   bool
-	Available bool
+// lightBrightness parses the brightness of a light state, nil if it reports none
+func lightBrightness(s *natureremo.LightState) *int {
+	if s == nil || s.Brightness == "" {
+		return nil
+	}
+	b, err := strconv.Atoi(s.Brightness)
+	if err != nil {
+		return nil
+	}
+	return &b
 }
 
 // getApplianceStatusFromAPIResponse extracts status from Nature Remo API response
-func getApplianceStatusFromAPIResponse(a *natureremo.Appliance) (*ApplianceStatus, error) {
-	status := &ApplianceStatus{
+func getApplianceStatusFromAPIResponse(a *natureremo.Appliance) (*pi.ApplianceStatus, error) {
+	status := &pi.ApplianceStatus{
 		ID:        a.ID,
 		Name:      a.Nickname,
 		Available: true,
@@ -184,6 +246,7 @@ func getApplianceStatusFromAPIResponse(a *natureremo.Appliance) (*ApplianceStatu
 	case natureremo.ApplianceTypeLight:
 		status.Type = "light"
 		status.PowerOn = a.Light.State.Power == "on"
+		status.Brightness = lightBrightness(a.Light.State)
 	case natureremo.ApplianceTypeTV:
 		status.Type = "tv"
 		// For TV, check if it has any available buttons (indicates it's responsive)
@@ -194,9 +257,10 @@ func getApplianceStatusFromAPIResponse(a *natureremo.Appliance) (*ApplianceStatu
 		status.PowerOn = len(a.Signals) > 0
 	case natureremo.ApplianceTypeAirCon:
 		status.Type = "aircon"
-		// For AC, check if it's on based on operation mode
+		// For AC, the last pressed button tells whether it is powered off
 		if a.AirConSettings != nil {
-			status.PowerOn = a.AirConSettings.OperationMode != ""
+			status.PowerOn = a.AirConSettings.Button != natureremo.ButtonPowerOff
+			status.AirCon = a.AirConSettings
 		} else {
 			status.PowerOn = false
 		}
@@ -208,54 +272,43 @@ func getApplianceStatusFromAPIResponse(a *natureremo.Appliance) (*ApplianceStatu
 	return status, nil
 }
 
-// publishApplianceStatusIfChanged publishes appliance status to MQTT only if changed
-func publishApplianceStatusIfChanged(applianceID, applianceName, applianceType string, powerState bool) {
-	// Update the last known state
-	lastKnownStates[applianceID] = &ApplianceStatus{
-		ID:        applianceID,
-		Name:      applianceName,
-		Type:      applianceType,
-		PowerOn:   powerState,
-		Available: true,
-	}
-
-	// Publish the status change
-	publishApplianceStatusChange(applianceID, applianceName, applianceType, powerState)
-}
-
 // publishApplianceStatusChange publishes appliance status changes to MQTT
-func publishApplianceStatusChange(applianceID, applianceName, applianceType string, powerState bool) {
+func publishApplianceStatusChange(status *pi.ApplianceStatus) {
 	if mqttClient == nil {
 		return
 	}
 
-	status := mqtt.Status{
-		ApplianceID:   applianceID,
-		ApplianceName: applianceName,
-		Type:          applianceType,
-		PowerState:    powerState,
+	mqttClient.PublishStatus(newMQTTStatus(status))
+}
+
+// newMQTTStatus converts an appliance status to its MQTT payload
+func newMQTTStatus(status *pi.ApplianceStatus) mqtt.Status {
+	return mqtt.Status{
+		ApplianceID:   status.ID,
+		ApplianceName: status.Name,
+		Type:          status.Type,
+		PowerState:    status.PowerOn,
 		Timestamp:     time.Now(),
+		Settings:      status.AirCon,
+		Brightness:    status.Brightness,
 	}
-
-	mqttClient.PublishStatus(status)
 }
 
 // executeApplianceCommandAndPublishStatus executes a command and publishes the resulting status
 func executeApplianceCommandAndPublishStatus(ctx context.Context, appliance pi.ApplianceData, command string) (err error) {
-	var s *natureremo.LightState
-	// Execute the command
-	switch command {
-	case "on":
-		s, err = executeApplianceOn(ctx, appliance)
-	case "off":
-		s, err = executeApplianceOff(ctx, appliance)
-	case "toggle":
-		s, err = executeApplianceToggle(ctx, appliance)
-	default:
-		// For other commands, just send the button
-		s, err = appliance.Sender.Send(ctx, command)
+	if appliance.Type == pi.ApplianceTypeAirCon {
+		return executeAirConCommandAndPublishStatus(ctx, appliance, mqtt.Command{Button: command})
 	}
 
+	// Execute the command, toggling from the last known state
+	last, known := states.Get(appliance.ID)
+	// Every attempt queues again so that retries do not hold up other commands while backing off
+	s, err := pi.SendButton(ctx, breakers, appliance, command, func(ctx context.Context) (*natureremo.LightState, error) {
+		return pi.Schedule(ctx, scheduler, pi.PriorityCommand, commandKey(appliance.ID, command),
+			func(ctx context.Context) (*natureremo.LightState, error) {
+				return pi.Execute(ctx, appliance, command, known && last.PowerOn)
+			})
+	})
 	if err != nil {
 		log.Printf("Failed to execute command %s for appliance %s: %v", command, appliance.ID, err)
 		return err
@@ -265,72 +318,141 @@ func executeApplianceCommandAndPublishStatus(ctx context.Context, appliance pi.A
 	time.Sleep(500 * time.Millisecond)
 
 	// Get the current status and publish to MQTT
-	status := &ApplianceStatus{
+	status := &pi.ApplianceStatus{
 		ID:        appliance.ID,
 		Name:      appliance.Name,
+		Type:      appliance.Type.StatusType(),
 		Available: true,
 	}
 
 	switch appliance.Type {
 	case pi.ApplianceTypeLight:
-		status.Type = "light"
+		if s == nil {
+			// Sent with the local API, which reports no state
+			switch command {
+			case "on", "off":
+				status.PowerOn = command == "on"
+			case "toggle":
+				status.PowerOn = !(known && last.PowerOn)
+			default:
+				status.PowerOn = known && last.PowerOn
+			}
+			break
+		}
 		status.PowerOn = s.Power == "on"
+		status.Brightness = lightBrightness(s)
 	case pi.ApplianceTypeTV:
-		status.Type = "tv"
 		// For TV, check if it has any available buttons (indicates it's responsive)
 		status.PowerOn = false
 	case pi.ApplianceTypeIR:
-		status.Type = "ir"
 		// For IR devices, assume they're available if they have signals
 		status.PowerOn = command == "on"
 	default:
-		status.Type = "unknown"
 		status.PowerOn = false
 	}
 
 	// Publish the actual status only if changed
-	publishApplianceStatusIfChanged(status.ID, status.Name, status.Type, status.PowerOn)
+	states.Set(*status)
 
 	return nil
 }
 
-// executeApplianceOn turns on an appliance and returns any error
-func executeApplianceOn(ctx context.Context, appliance pi.ApplianceData) (status *natureremo.LightState, err error) {
-	switch appliance.Type {
-	case pi.ApplianceTypeLight:
-		return appliance.Sender.On(ctx)
-	case pi.ApplianceTypeLocal:
-		return appliance.Sender.On(ctx)
+// executeAirConCommandAndPublishStatus applies the settings of a command to an air conditioner and publishes them
+func executeAirConCommandAndPublishStatus(ctx context.Context, appliance pi.ApplianceData, cmd mqtt.Command) error {
+	sender, ok := appliance.Sender.(pi.AirConSender)
+	if !ok {
+		return fmt.Errorf("appliance %s does not accept aircon settings", appliance.ID)
+	}
+
+	settings := natureremo.AirConSettings{
+		Temperature:   cmd.Temperature,
+		OperationMode: natureremo.OperationMode(cmd.OperationMode),
+		AirVolume:     natureremo.AirVolume(cmd.AirVolume),
+		AirDirection:  natureremo.AirDirection(cmd.AirDirection),
+	}
+	last, known := states.Get(appliance.ID)
+	switch cmd.Button {
+	case "on":
+		settings.Button = natureremo.ButtonPowerOn
+	case "off":
+		settings.Button = natureremo.ButtonPowerOff
+	case "toggle":
+		if known && last.PowerOn {
+			settings.Button = natureremo.ButtonPowerOff
+		} else {
+			settings.Button = natureremo.ButtonPowerOn
+		}
+	case "":
+		// Settings only, keep the current power state
+		if known && !last.PowerOn {
+			settings.Button = natureremo.ButtonPowerOff
+		}
 	default:
-		return appliance.Sender.Send(ctx, "on")
+		settings.Button = natureremo.Button(cmd.Button)
+	}
+
+	s, err := pi.Send(ctx, breakers, appliance, func(ctx context.Context) (*natureremo.AirConSettings, error) {
+		return pi.Schedule(ctx, scheduler, pi.PriorityCommand, "", func(ctx context.Context) (*natureremo.AirConSettings, error) {
+			return sender.UpdateSettings(ctx, settings)
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to update aircon settings for appliance %s: %v", appliance.ID, err)
+		return err
+	}
+
+	states.Set(pi.ApplianceStatus{
+		ID:        appliance.ID,
+		Name:      appliance.Name,
+		Type:      appliance.Type.StatusType(),
+		PowerOn:   s.Button != natureremo.ButtonPowerOff,
+		Available: true,
+		AirCon:    s,
+	})
+	return nil
+}
+
+// publishEnergySummary publishes the consumption of a smart meter in a completed day or month
+func publishEnergySummary(s metrics.EnergySummary) {
+	if mqttClient == nil {
+		return
+	}
+	err := mqttClient.PublishEnergySummary(mqtt.EnergySummary{
+		DeviceID:   s.ID,
+		DeviceName: s.Name,
+		Period:     s.Period,
+		Start:      s.Start,
+		KWh:        s.KWh,
+		Cost:       s.Cost,
+		Timestamp:  time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish energy summary of %s: %v", s.ID, err)
 	}
 }
 
-// executeApplianceOff turns off an appliance and returns any error
-func executeApplianceOff(ctx context.Context, appliance pi.ApplianceData) (status *natureremo.LightState, err error) {
-	switch appliance.Type {
-	case pi.ApplianceTypeLight:
-		return appliance.Sender.Off(ctx)
-	case pi.ApplianceTypeLocal:
-		return appliance.Sender.Off(ctx)
-	default:
-		return appliance.Sender.Send(ctx, "off")
+// publishBreaker tells others about the circuit breaker of a device
+func publishBreaker(device string, state pi.BreakerState) {
+	if mqttClient == nil {
+		return
+	}
+	err := mqttClient.PublishBreaker(mqtt.Breaker{
+		Device:    device,
+		State:     state.String(),
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish breaker of %s: %v", device, err)
 	}
 }
 
-// executeApplianceToggle toggles an appliance state
-func executeApplianceToggle(ctx context.Context, appliance pi.ApplianceData) (status *natureremo.LightState, err error) {
-	if appliance.Type == pi.ApplianceTypeLight {
-		status, ok := lastKnownStates[appliance.ID]
-		if ok && status.PowerOn {
-			return executeApplianceOff(ctx, appliance)
-		} else {
-			return executeApplianceOn(ctx, appliance)
-		}
-	} else {
-		// For other types, just send toggle command
-		return appliance.Sender.Send(ctx, "toggle")
+// commandKey coalesces repeated on and off commands to an appliance waiting in the scheduler,
+// any other button is sent as often as it was pressed
+func commandKey(applianceID, command string) string {
+	if command != "on" && command != "off" {
+		return ""
 	}
+	return "command/" + applianceID + "/" + command
 }
 
 // publishFallbackStatus publishes expected status when API status check fails
@@ -349,5 +471,11 @@ func publishFallbackStatus(appliance pi.ApplianceData, command string) {
 		powerState = true
 	}
 
-	publishApplianceStatusChange(appliance.ID, appliance.Name, string(appliance.Type), powerState)
+	publishApplianceStatusChange(&pi.ApplianceStatus{
+		ID:        appliance.ID,
+		Name:      appliance.Name,
+		Type:      string(appliance.Type),
+		PowerOn:   powerState,
+		Available: true,
+	})
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/cormoran/natureremo"
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/metrics"
+)
+
+// reconcile polls the Cloud API every interval so that changes made outside of this daemon,
+// e.g. from the Nature app, are published as well
+func reconcile(ctx context.Context, client *natureremo.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var reachable *bool
+	for {
+		err := reconcileOnce(ctx, client)
+		if err != nil {
+			log.Printf("Failed to reconcile appliance states: %v", err)
+		}
+		// Let the panels show whether commands can reach the Remo API
+		if ok := err == nil; mqttClient != nil && (reachable == nil || *reachable != ok) {
+			if err := mqttClient.PublishAPIStatus(ok); err != nil {
+				log.Printf("Failed to publish API status: %v", err)
+			} else {
+				reachable = &ok
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconcileOnce publishes the states of configured appliances which differ from the last known ones
+// and the latest sensor values of every Remo device
+func reconcileOnce(ctx context.Context, client *natureremo.Client) error {
+	appliances, err := pi.Schedule(ctx, scheduler, pi.PriorityBackground, metrics.RequestKeyAppliances, client.ApplianceService.GetAll)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range appliances {
+		if _, ok := config.Appliances[a.ID]; !ok {
+			continue
+		}
+		// Only lights and air conditioners report their state through the API
+		switch a.Type {
+		case natureremo.ApplianceTypeLight:
+			if a.Light == nil || a.Light.State == nil {
+				continue
+			}
+		case natureremo.ApplianceTypeAirCon:
+			if a.AirConSettings == nil {
+				continue
+			}
+		default:
+			continue
+		}
+
+		status, err := getApplianceStatusFromAPIResponse(a)
+		if err != nil {
+			log.Printf("Failed to read status of appliance %s: %v", a.ID, err)
+			continue
+		}
+		states.Set(*status)
+	}
+	// Unchanged states are not delivered to subscribers, tell the collector they are current
+	for id, status := range states.All() {
+		if _, ok := config.Appliances[id]; ok {
+			collector.UpdateApplianceState(status.ID, status.Name, status.Type, status.PowerOn)
+		}
+	}
+
+	devices, err := pi.Schedule(ctx, scheduler, pi.PriorityBackground, metrics.RequestKeyDevices, client.DeviceService.GetAll)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if mqttClient == nil || len(sensorKeys(d)) == 0 {
+			continue
+		}
+		if err := mqttClient.PublishSensor(sensorFromDevice(d)); err != nil {
+			log.Printf("Failed to publish sensor values of %s: %v", d.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/mqtt"
+)
+
+// startTimer turns the appliance off after d, restarting its timer if it is running
+func startTimer(appliance pi.ApplianceData, d time.Duration) {
+	states.StartTimer(appliance.ID, d, func() {
+		fmt.Println("TIMER", appliance.Name, "End")
+		executeApplianceCommandAndPublishStatus(context.Background(), appliance, "off")
+	})
+}
+
+// restoreTimers restarts the timers which were running when control-remo stopped,
+// those which ran out in the meantime turn their appliances off right away
+func restoreTimers() {
+	for id, deadline := range states.Deadlines() {
+		appliance, ok := config.Appliances[id]
+		if !ok || appliance.Trigger != pi.TriggerTimer {
+			states.StopTimer(id)
+			continue
+		}
+		d := time.Until(deadline)
+		if d < 0 {
+			fmt.Println("TIMER", appliance.Name, "Overdue")
+			d = 0
+		} else {
+			fmt.Println("TIMER", appliance.Name, "Restore")
+		}
+		startTimer(appliance, d)
+	}
+}
+
+// recordCommand adds a handled command to the history
+func recordCommand(cmd mqtt.Command, err error) {
+	record := pi.CommandRecord{
+		Time:        time.Now(),
+		ApplianceID: cmd.ApplianceID,
+		Button:      cmd.Button,
+		RequestID:   cmd.RequestID,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	store.AddCommand(record)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/eivy/control-remo-from-pi/mqtt"
+)
+
+// statusSync republishes the known states of the configured appliances to MQTT, retained, so that
+// the broker holds them even after losing its retained messages; changes are published as they happen
+type statusSync struct {
+	interval time.Duration
+	trigger  chan struct{}
+}
+
+// newStatusSync returns a sync publishing every interval, only when triggered if interval is 0
+func newStatusSync(interval time.Duration) *statusSync {
+	return &statusSync{interval: interval, trigger: make(chan struct{}, 1)}
+}
+
+// Trigger republishes the states soon, e.g. after connecting to a broker which may have restarted;
+// a trigger before Run is kept until it starts
+func (s *statusSync) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run republishes the states with client on every interval and trigger until ctx is done
+func (s *statusSync) Run(ctx context.Context, client *mqtt.Client) {
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-s.trigger:
+		case <-ctx.Done():
+			return
+		}
+		s.publish(client)
+	}
+}
+
+func (s *statusSync) publish(client *mqtt.Client) {
+	n := 0
+	for id, status := range states.All() {
+		if _, ok := config.Appliances[id]; !ok {
+			continue
+		}
+		if err := client.PublishStatus(newMQTTStatus(&status)); err != nil {
+			log.Printf("Failed to sync status of %s: %v", id, err)
+			continue
+		}
+		n++
+	}
+	log.Printf("Synced %d appliance states to MQTT", n)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cormoran/natureremo"
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/mqtt"
+)
+
+// directTimeout limits a command executed directly with the Remo API
+const directTimeout = 30 * time.Second
+
+// directQueue is the number of presses waiting to be executed directly before further ones are dropped
+const directQueue = 16
+
+// broker is the part of the MQTT client the fallback publisher needs
+type broker interface {
+	publisher
+	PublishStatus(status mqtt.Status) error
+	IsConnected() bool
+}
+
+var fallback = newFallbackPublisher(nil, false)
+
+// fallbackPublisher publishes presses through MQTT while connected and, with a Remo token,
+// executes them directly with the appliance senders while the broker is unreachable; direct commands
+// run one at a time like those of control-remo, the Remo client and senders are not safe for concurrent use
+type fallbackPublisher struct {
+	broker broker
+	direct bool
+
+	mu      sync.Mutex
+	states  map[string]mqtt.Status // last known state by appliance ID
+	offline map[string]mqtt.Status // states set directly, published once the broker returns
+
+	queue   chan mqtt.Command
+	worker  sync.Once
+	running sync.WaitGroup // commands queued or being executed directly
+}
+
+func newFallbackPublisher(b broker, direct bool) *fallbackPublisher {
+	return &fallbackPublisher{
+		broker:  b,
+		direct:  direct,
+		states:  make(map[string]mqtt.Status),
+		offline: make(map[string]mqtt.Status),
+		queue:   make(chan mqtt.Command, directQueue),
+	}
+}
+
+func (f *fallbackPublisher) PublishCommand(cmd mqtt.Command) error {
+	if f.broker.IsConnected() {
+		return f.broker.PublishCommand(cmd)
+	}
+	if !f.direct {
+		log.Printf("MQTT broker unreachable, dropping %s for %s", cmd.Button, cmd.ApplianceID)
+		return fmt.Errorf("MQTT broker unreachable")
+	}
+	f.worker.Do(func() { go f.work() })
+	f.running.Add(1)
+	select {
+	case f.queue <- cmd:
+		return nil
+	default:
+		f.running.Done()
+		log.Printf("Too many direct commands waiting, dropping %s for %s", cmd.Button, cmd.ApplianceID)
+		return fmt.Errorf("too many direct commands waiting")
+	}
+}
+
+// work executes the queued commands in turn
+func (f *fallbackPublisher) work() {
+	for cmd := range f.queue {
+		f.execute(cmd)
+		f.running.Done()
+	}
+}
+
+func (f *fallbackPublisher) PublishGate(gate mqtt.Gate) error {
+	if !f.broker.IsConnected() {
+		// Gates are informational, there is nobody to tell
+		return nil
+	}
+	return f.broker.PublishGate(gate)
+}
+
+// remember records the state of an appliance published by control-remo
+func (f *fallbackPublisher) remember(sts mqtt.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[sts.ApplianceID] = sts
+}
+
+// execute sends the command with the appliance sender and reports it as if control-remo had handled it
+func (f *fallbackPublisher) execute(cmd mqtt.Command) {
+	start := time.Now()
+	a, ok := config.Appliances[cmd.ApplianceID]
+	if !ok {
+		log.Printf("Unknown appliance: %s", cmd.ApplianceID)
+		return
+	}
+
+	f.mu.Lock()
+	last, known := f.states[a.ID]
+	f.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), directTimeout)
+	defer cancel()
+	log.Printf("MQTT broker unreachable, sending %s to %s directly", cmd.Button, a.Name)
+	s, err := pi.SendButton(ctx, nil, a, cmd.Button, func(ctx context.Context) (*natureremo.LightState, error) {
+		return pi.Execute(ctx, a, cmd.Button, known && last.PowerState)
+	})
+	result := mqtt.Result{
+		RequestID:   cmd.RequestID,
+		ApplianceID: a.ID,
+		Button:      cmd.Button,
+		Success:     err == nil,
+		Latency:     time.Since(start).Seconds(),
+		Timestamp:   time.Now(),
+	}
+	if err != nil {
+		log.Printf("Failed to execute %s for %s directly: %v", cmd.Button, a.ID, err)
+		result.Error = err.Error()
+	}
+	results.HandleResult(result)
+	if err != nil {
+		return
+	}
+
+	status := mqtt.Status{
+		ApplianceID:   a.ID,
+		ApplianceName: a.Name,
+		Type:          a.Type.StatusType(),
+		Timestamp:     time.Now(),
+	}
+	switch {
+	case s != nil && s.Power != "":
+		status.PowerState = s.Power == "on"
+	case cmd.Button == "on" || cmd.Button == "off":
+		status.PowerState = cmd.Button == "on"
+	case cmd.Button == "toggle" && (a.Type == pi.ApplianceTypeLight || a.Type == pi.ApplianceTypeAirCon):
+		status.PowerState = !(known && last.PowerState)
+	default:
+		// The resulting state is unknown
+		return
+	}
+	f.mu.Lock()
+	f.offline[a.ID] = status
+	f.mu.Unlock()
+	(&MQTTStatusHandler{}).HandleStatus(status)
+}
+
+// resync publishes the states set while the broker was unreachable so that control-remo and others catch up
+func (f *fallbackPublisher) resync() {
+	f.mu.Lock()
+	offline := f.offline
+	f.offline = make(map[string]mqtt.Status)
+	f.mu.Unlock()
+	for id, status := range offline {
+		if err := f.broker.PublishStatus(status); err != nil {
+			log.Printf("Failed to resync state of %s: %v", id, err)
+			f.mu.Lock()
+			if _, ok := f.offline[id]; !ok {
+				f.offline[id] = status
+			}
+			f.mu.Unlock()
+		}
+	}
+	if len(offline) > 0 {
+		log.Printf("Resynced %d appliance states to MQTT", len(offline))
+	}
+}
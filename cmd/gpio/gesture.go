@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/gpio"
+)
+
+// chordWindow is how close together the pins of a chord must be pressed
+const chordWindow = 200 * time.Millisecond
+
+// newRecognizers returns gesture recognizers by switch pin for the TOGGLE appliances which have
+// gestures configured or are part of a chord
+func newRecognizers(c publisher) map[int]*gpio.Recognizer {
+	recognizers := make(map[int]*gpio.Recognizer)
+	for _, a := range config.Appliances {
+		if a.SwitchPin == nil || a.Trigger != pi.TriggerTOGGLE {
+			continue
+		}
+		if len(a.Gestures) == 0 && !inChord(*a.SwitchPin) {
+			continue
+		}
+		_, double := a.Gestures[string(gpio.GestureDouble)]
+		_, hold := a.Gestures[string(gpio.GestureHold)]
+		opts := gpio.RecognizerOptions{
+			Double:      double,
+			Hold:        hold,
+			LongPress:   config.LongPress,
+			DoublePress: config.DoublePress,
+			HoldRepeat:  config.HoldRepeat,
+		}
+		recognizers[*a.SwitchPin] = gpio.NewRecognizer(opts, func(g gpio.Gesture) {
+			fmt.Println(a.Name, g)
+			runAction(c, gestureAction(a, g), []string{a.ID})
+		})
+	}
+	return recognizers
+}
+
+// gestureAction returns the action of the gesture, gestures without one act like a short press
+func gestureAction(a pi.ApplianceData, g gpio.Gesture) pi.Action {
+	if action, ok := a.Gestures[string(g)]; ok {
+		return action
+	}
+	if action, ok := a.Gestures[string(gpio.GesturePress)]; ok {
+		return action
+	}
+	return pi.Action{Button: "toggle"}
+}
+
+// runAction publishes the button of the action to its appliances, or to the given ones if it has none
+func runAction(c publisher, action pi.Action, appliances []string) {
+	if len(action.Appliances) > 0 {
+		appliances = action.Appliances
+	}
+	button := action.Button
+	if button == "" {
+		button = "toggle"
+	}
+	for _, id := range appliances {
+		a, ok := config.Appliances[id]
+		if !ok {
+			log.Printf("Unknown appliance in action: %s", id)
+			continue
+		}
+		publishGated(c, a, button)
+	}
+}
+
+// inChord returns whether the pin is part of a chord
+func inChord(pin int) bool {
+	for _, chord := range config.Chords {
+		if slices.Contains(chord.Pins, pin) {
+			return true
+		}
+	}
+	return false
+}
+
+// chordTracker recognizes chords and consumes the events of their pins until they are released
+type chordTracker struct {
+	pressed  map[int]time.Time // pins of chords by the time they were pressed
+	consumed map[int]bool      // pins of a recognized chord which are still pressed
+}
+
+func newChordTracker() *chordTracker {
+	return &chordTracker{pressed: make(map[int]time.Time), consumed: make(map[int]bool)}
+}
+
+// handle returns whether the event belongs to a chord, running the chord once all its pins are pressed
+func (t *chordTracker) handle(c publisher, e switchEvent, recognizers map[int]*gpio.Recognizer) bool {
+	if !inChord(e.Pin) {
+		return false
+	}
+	if e.Level == gpio.Low {
+		delete(t.pressed, e.Pin)
+		if t.consumed[e.Pin] {
+			delete(t.consumed, e.Pin)
+			return true
+		}
+		return false
+	}
+	now := time.Now()
+	t.pressed[e.Pin] = now
+	for _, chord := range config.Chords {
+		if !slices.Contains(chord.Pins, e.Pin) || !t.complete(chord, now) {
+			continue
+		}
+		fmt.Println("chord", chord.Pins)
+		var appliances []string
+		for _, pin := range chord.Pins {
+			t.consumed[pin] = true
+			if r, ok := recognizers[pin]; ok {
+				r.Cancel()
+			}
+			for id, a := range config.Appliances {
+				if a.SwitchPin != nil && *a.SwitchPin == pin {
+					appliances = append(appliances, id)
+				}
+			}
+		}
+		runAction(c, chord.Action, appliances)
+		return true
+	}
+	return t.consumed[e.Pin]
+}
+
+// complete returns whether all pins of the chord were pressed within the chord window
+func (t *chordTracker) complete(chord pi.Chord, now time.Time) bool {
+	for _, pin := range chord.Pins {
+		pressed, ok := t.pressed[pin]
+		if !ok || t.consumed[pin] || now.Sub(pressed) > chordWindow {
+			return false
+		}
+	}
+	return len(chord.Pins) > 0
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/gpio"
+)
+
+const (
+	// errorDisplay is how long a status LED shows a failed command
+	errorDisplay = 3 * time.Second
+	// pendingTimeout stops showing a command in flight whose result never arrives
+	pendingTimeout = 10 * time.Second
+)
+
+var animator = gpio.NewAnimator()
+var statusLEDs = make(map[string]*statusLED) // by appliance ID
+var health = &healthState{mqtt: true, remo: true, api: true}
+
+// statusLED shows the power state of an appliance, overlaid by the progress of commands and the health of the system
+type statusLED struct {
+	led    *gpio.LED
+	config pi.StatusLED
+
+	mu           sync.Mutex
+	on           bool
+	brightness   *int
+	pendingUntil time.Time
+	errorUntil   time.Time
+}
+
+func newStatusLED(out gpio.Output, config *pi.StatusLED) *statusLED {
+	l := &statusLED{led: animator.Add(out, true)}
+	if config != nil {
+		l.config = *config
+	}
+	return l
+}
+
+// setState shows the power state and brightness reported for the appliance
+func (l *statusLED) setState(on bool, brightness *int) {
+	l.mu.Lock()
+	l.on = on
+	l.brightness = brightness
+	l.mu.Unlock()
+	l.show()
+}
+
+// sent shows a command in flight until its result arrives
+func (l *statusLED) sent() {
+	l.mu.Lock()
+	l.pendingUntil = time.Now().Add(pendingTimeout)
+	l.mu.Unlock()
+	l.show()
+	time.AfterFunc(pendingTimeout, l.show)
+}
+
+// done ends showing the command in flight, showing an error for a while if it failed
+func (l *statusLED) done(success bool) {
+	l.mu.Lock()
+	l.pendingUntil = time.Time{}
+	if !success {
+		l.errorUntil = time.Now().Add(errorDisplay)
+		time.AfterFunc(errorDisplay, l.show)
+	}
+	l.mu.Unlock()
+	l.show()
+}
+
+func (l *statusLED) show() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.led.Set(l.pattern(time.Now()))
+}
+
+// pattern returns the pattern of the most important thing to show and its brightness
+func (l *statusLED) pattern(now time.Time) (gpio.Pattern, int) {
+	overlays := []struct {
+		active  bool
+		pattern string
+		preset  gpio.Pattern
+	}{
+		{health.unreachable(), l.config.Unreachable, gpio.PatternPulse},
+		{now.Before(l.errorUntil), l.config.Error, gpio.PatternFastBlink},
+		{now.Before(l.pendingUntil), l.config.Pending, gpio.PatternBlink},
+	}
+	for _, o := range overlays {
+		if !o.active || o.pattern == "none" {
+			continue
+		}
+		if o.pattern == "" {
+			return o.preset, 100
+		}
+		return gpio.Pattern(o.pattern), 100
+	}
+	if !l.on {
+		return gpio.PatternOff, 0
+	}
+	if l.config.Dim && l.brightness != nil {
+		return gpio.PatternSolid, *l.brightness
+	}
+	return gpio.PatternSolid, 100
+}
+
+// healthState tracks whether presses can reach the appliances: through MQTT, control-remo and the Remo API
+type healthState struct {
+	mu   sync.Mutex
+	mqtt bool
+	remo bool
+	api  bool
+}
+
+func (h *healthState) unreachable() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.mqtt || !h.remo || !h.api
+}
+
+// update applies the change to the health and refreshes the status LEDs if it changed
+func (h *healthState) update(change func(h *healthState)) {
+	before := h.unreachable()
+	h.mu.Lock()
+	change(h)
+	h.mu.Unlock()
+	if h.unreachable() == before {
+		return
+	}
+	fmt.Println("appliances unreachable:", !before)
+	for _, l := range statusLEDs {
+		l.show()
+	}
+}
+
+func (h *healthState) HandleAPIStatus(reachable bool) error {
+	fmt.Println("Remo API reachable:", reachable)
+	h.update(func(h *healthState) { h.api = reachable })
+	return nil
+}
+
+// watchMQTT follows the connection of the panel to the broker
+func (h *healthState) watchMQTT(ctx context.Context, connected func() bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok := connected()
+			h.update(func(h *healthState) { h.mqtt = ok })
+		case <-ctx.Done():
+			return
+		}
+	}
+}
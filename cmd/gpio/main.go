@@ -5,16 +5,32 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/cormoran/natureremo"
 	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/gpio"
 	"github.com/eivy/control-remo-from-pi/mqtt"
-	rpio "github.com/stianeikeland/go-rpio"
 )
 
+// defaultDebounce applies to appliances without a Debounce setting
+const defaultDebounce = 20 * time.Millisecond
+
 var config pi.Config
 var mqttClient *mqtt.Client
+var pins gpio.Backend
+var results = &resultHandler{pending: make(map[string]time.Time)}
+var conditions = make(map[string]gpio.Input) // condition pins by appliance ID
+var outputs = make(map[int]gpio.Output)
+var outputsMu sync.Mutex
+
+// publisher is the part of the MQTT client the panel publishes presses with
+type publisher interface {
+	PublishCommand(cmd mqtt.Command) error
+	PublishGate(gate mqtt.Gate) error
+}
 
 func main() {
 	var err error
@@ -23,35 +39,47 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Initialize MQTT client if broker is configured
-	mqttBroker := os.Getenv("MQTT_BROKER")
-	if mqttBroker == "" {
-		log.Fatal("set MQTT_BROKER")
+	// Open the pins before any MQTT handler may drive them
+	pins, err = gpio.Open(config.GPIOBackend, config.GPIOChip)
+	if err != nil {
+		log.Fatalf("Failed to open GPIO: %v", err)
 	}
-	mqttPortStr := os.Getenv("MQTT_PORT")
-	if mqttPortStr == "" {
-		mqttPortStr = "1883"
+	defer pins.Close()
+	if sim, ok := pins.(*gpio.Sim); ok && config.GPIOSim != "" {
+		go serveSim(sim, config.GPIOSim)
 	}
-	mqttPort, err := strconv.Atoi(mqttPortStr)
-	if err != nil {
-		log.Fatalf("Invalid MQTT_PORT: %v", err)
+	if err := openStatusLEDs(); err != nil {
+		log.Fatal(err)
 	}
 
-	mqttConfig := mqtt.Config{
-		Broker:   mqttBroker,
-		Port:     mqttPort,
-		Username: os.Getenv("MQTT_USERNAME"),
-		Password: os.Getenv("MQTT_PASSWORD"),
-		ClientID: os.Getenv("MQTT_CLIENT_ID"),
+	// Initialize MQTT client from MQTT_* environment variables
+	mqttConfig, err := mqtt.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
-
 	if mqttConfig.ClientID == "" {
-		mqttConfig.ClientID = "remo-controller"
+		// Must differ from control-remo, the broker drops the older session of a client ID
+		mqttConfig.ClientID = "remo-gpio"
+	}
+
+	mqttConfig.Topics = config.Topics()
+	// Keep the panel working while the broker is down, catching up once it returns
+	mqttConfig.ConnectRetry = true
+	mqttConfig.OnConnect = func() { fallback.resync() }
+
+	// With a Remo token presses are sent directly while the broker is unreachable
+	remoSecret := os.Getenv("REMO_SECRET")
+	if remoSecret != "" {
+		pi.SetRemoClient(natureremo.NewClient(remoSecret))
 	}
 
-	mqttClient = mqtt.NewClient(mqttConfig)
+	mqttClient, err = mqtt.NewClient(mqttConfig)
+	if err != nil {
+		log.Fatalf("Failed to create MQTT client: %v", err)
+	}
+	fallback = newFallbackPublisher(mqttClient, remoSecret != "")
 	if err := mqttClient.Connect(); err != nil {
-		log.Fatalf("Failed to connect to MQTT broker: %v", err)
+		log.Printf("Failed to connect to MQTT broker: %v", err)
 	} else {
 		log.Printf("MQTT client initialized successfully")
 	}
@@ -62,18 +90,148 @@ func main() {
 		log.Printf("Failed to subscribe to MQTT commands: %v", err)
 	}
 
-	rpio.Open()
-	ch := make(chan pi.ApplianceData)
+	var availabilityPin gpio.Output
+	if config.AvailabilityPin != nil {
+		availabilityPin, err = output(*config.AvailabilityPin)
+		if err != nil {
+			log.Fatalf("Failed to open availability pin: %v", err)
+		}
+	}
+	h := newAvailabilityHandler(availabilityPin)
+	if err := mqttClient.SubscribeAvailability(h); err != nil {
+		log.Printf("Failed to subscribe to MQTT availability: %v", err)
+	}
+	if availabilityPin != nil {
+		go h.run(ctx)
+	}
+	if err := mqttClient.SubscribeAPIStatus(health); err != nil {
+		log.Printf("Failed to subscribe to MQTT API status: %v", err)
+	}
+	go health.watchMQTT(ctx, mqttClient.IsConnected)
+	go animator.Run(ctx)
+
+	if err := mqttClient.SubscribeResults(results); err != nil {
+		log.Printf("Failed to subscribe to MQTT results: %v", err)
+	}
+
+	ch := make(chan switchEvent)
+	if err := watchAppliances(ctx, ch, fallback); err != nil {
+		log.Fatal(err)
+	}
+
+	buttonHandler(ctx, ch, fallback)
+}
+
+// openStatusLEDs opens the status pins of every appliance with a switch; the map is only read
+// afterwards, so it must be filled before any MQTT handler runs
+func openStatusLEDs() error {
 	for _, a := range config.Appliances {
+		if a.SwitchPin == nil || a.StatusPin == nil {
+			continue
+		}
+		out, err := output(*a.StatusPin)
+		if err != nil {
+			return fmt.Errorf("failed to open status pin of %s: %v", a.Name, err)
+		}
+		statusLEDs[a.ID] = newStatusLED(out, a.StatusLED)
+	}
+	return nil
+}
+
+// watchAppliances opens the inputs of every appliance and sends changes of their switches to ch
+func watchAppliances(ctx context.Context, ch chan switchEvent, c publisher) error {
+	switches := make(map[int]bool)
+	for _, a := range config.Appliances {
+		if a.SwitchPin == nil {
+			continue
+		}
 		fmt.Println(a.Name)
-		in := rpio.Pin(*a.SwitchPin)
-		in.Mode(rpio.Input)
-		go pinCheck(in, a, ch)
-		out := rpio.Pin(*a.StatusPin)
-		out.Mode(rpio.Output)
+		pull, debounce := inputSettings(a)
+		in, err := pins.Input(*a.SwitchPin, pull, debounce)
+		if err != nil {
+			return fmt.Errorf("failed to open switch of %s: %v", a.Name, err)
+		}
+		switches[*a.SwitchPin] = true
+		go pinCheck(ctx, in, *a.SwitchPin, a, ch)
+		if a.ConditionPin != nil {
+			cond, err := pins.Input(*a.ConditionPin, pull, debounce)
+			if err != nil {
+				return fmt.Errorf("failed to open condition of %s: %v", a.Name, err)
+			}
+			conditions[a.ID] = cond
+			go conditionCheck(ctx, cond, a, c)
+		}
+		if a.Trigger == pi.TriggerSYNC {
+			// Bring the appliance to the current switch position
+			go func(a pi.ApplianceData, level gpio.Level) {
+				select {
+				case ch <- switchEvent{Pin: *a.SwitchPin, Appliance: a, Level: level}:
+				case <-ctx.Done():
+				}
+			}(a, in.Read())
+		}
+	}
+	// Pins used only in chords
+	for _, chord := range config.Chords {
+		for _, pin := range chord.Pins {
+			if switches[pin] {
+				continue
+			}
+			in, err := pins.Input(pin, "", defaultDebounce)
+			if err != nil {
+				return fmt.Errorf("failed to open chord pin %d: %v", pin, err)
+			}
+			switches[pin] = true
+			go pinCheck(ctx, in, pin, pi.ApplianceData{Name: fmt.Sprint("pin ", pin)}, ch)
+		}
 	}
+	return nil
+}
+
+// output returns the output pin, opening it on first use
+func output(pin int) (gpio.Output, error) {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	if out, ok := outputs[pin]; ok {
+		return out, nil
+	}
+	out, err := pins.Output(pin)
+	if err != nil {
+		return nil, err
+	}
+	outputs[pin] = out
+	return out, nil
+}
+
+// serveSim drives the simulated pins from a file ("file:/path", e.g. a named pipe) or a socket
+func serveSim(sim *gpio.Sim, addr string) {
+	path, ok := strings.CutPrefix(addr, "file:")
+	if !ok {
+		log.Printf("Simulated pins stopped: %v", sim.ListenAndServe(addr))
+		return
+	}
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Simulated pins stopped: %v", err)
+			return
+		}
+		err = sim.Serve(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Simulated pins stopped: %v", err)
+			return
+		}
+		// A named pipe ends whenever its writer closes, wait for the next one
+		time.Sleep(100 * time.Millisecond)
+	}
+}
 
-	buttonHandler(ctx, ch, mqttClient)
+// switchEvent is a level change on a switch pin, Appliance is empty for pins used only in chords
+type switchEvent struct {
+	Pin       int
+	Appliance pi.ApplianceData
+	Level     gpio.Level
 }
 
 type MQTTStatusHandler struct{}
@@ -84,41 +242,234 @@ func (h *MQTTStatusHandler) HandleStatus(sts mqtt.Status) error {
 	if !exists {
 		return fmt.Errorf("appliance not found: %s", sts.ApplianceID)
 	}
-	out := rpio.Pin(*appliance.StatusPin)
-	if sts.PowerState {
-		out.Write(rpio.Low)
-	} else {
-		out.Write(rpio.High)
+	fallback.remember(sts)
+	if l, ok := statusLEDs[appliance.ID]; ok {
+		l.setState(sts.PowerState, sts.Brightness)
 	}
 	return nil
 }
 
-func pinCheck(in rpio.Pin, a pi.ApplianceData, ch chan pi.ApplianceData) {
-	before := in.Read()
-	for {
-		tmp := in.Read()
-		if before != tmp {
-			if tmp == rpio.High {
-				ch <- a
-			}
-			before = tmp
+func pinCheck(ctx context.Context, in gpio.Input, pin int, a pi.ApplianceData, ch chan switchEvent) {
+	err := in.Watch(ctx, func(level gpio.Level) {
+		// The button handler is gone once ctx is done
+		select {
+		case ch <- switchEvent{Pin: pin, Appliance: a, Level: level}:
+		case <-ctx.Done():
+		}
+	})
+	log.Printf("Stopped watching switch of %s: %v", a.Name, err)
+}
+
+// inputSettings returns the bias and debounce time of the input pins of the appliance
+func inputSettings(a pi.ApplianceData) (pull gpio.Pull, debounce time.Duration) {
+	if a.Pull != nil {
+		pull = gpio.Pull(strings.ToLower(*a.Pull))
+	}
+	debounce = defaultDebounce
+	if a.Debounce != nil {
+		d, err := time.ParseDuration(*a.Debounce)
+		if err != nil {
+			log.Printf("Invalid debounce for appliance %s: %v", a.ID, err)
+		} else {
+			debounce = d
 		}
-		time.Sleep(time.Millisecond * 100)
 	}
+	return
 }
 
-func buttonHandler(ctx context.Context, ch chan pi.ApplianceData, c *mqtt.Client) {
+func buttonHandler(ctx context.Context, ch chan switchEvent, c publisher) {
+	recognizers := newRecognizers(c)
+	chords := newChordTracker()
 	for {
 		select {
-		case v := <-ch:
-			fmt.Println(v.Name)
+		case e := <-ch:
+			v := e.Appliance
+			fmt.Println(v.Name, e.Level)
+			if chords.handle(c, e, recognizers) {
+				// Let the canceled recognizer see the release so that it is ready for the next press
+				if r, ok := recognizers[e.Pin]; ok && e.Level == gpio.Low {
+					r.Handle(e.Level)
+				}
+				continue
+			}
 			switch v.Trigger {
 			case pi.TriggerTOGGLE:
-				c.PublishCommand(mqtt.Command{
-					ApplianceID: v.ID,
-					Button:      "toggle",
-				})
+				if r, ok := recognizers[e.Pin]; ok {
+					r.Handle(e.Level)
+					break
+				}
+				// Momentary switch, act on press only
+				if e.Level == gpio.High {
+					publishGated(c, v, "toggle")
+				}
+			case pi.TriggerSYNC:
+				// Latching switch, its position is the desired state
+				button := "off"
+				if e.Level == gpio.High {
+					button = "on"
+				}
+				publishGated(c, v, button)
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishGated publishes the button unless the condition pin of the appliance inhibits it
+func publishGated(c publisher, a pi.ApplianceData, button string) {
+	if cond, ok := conditions[a.ID]; ok && cond.Read() == inhibitLevel(a) {
+		gate := mqtt.Gate{
+			ApplianceID: a.ID,
+			Inhibited:   true,
+			Suppressed:  button,
+			Timestamp:   time.Now(),
+		}
+		if a.ConditionButton == nil {
+			fmt.Println(a.Name, "inhibited, dropping", button)
+			c.PublishGate(gate)
+			return
+		}
+		fmt.Println(a.Name, "inhibited, sending", *a.ConditionButton, "instead of", button)
+		gate.Remapped = *a.ConditionButton
+		c.PublishGate(gate)
+		button = *a.ConditionButton
+	}
+	c.PublishCommand(mqtt.Command{
+		ApplianceID: a.ID,
+		Button:      button,
+		RequestID:   results.add(a.ID),
+	})
+}
+
+// inhibitLevel returns the level of the condition pin which inhibits presses
+func inhibitLevel(a pi.ApplianceData) gpio.Level {
+	if a.ConditionLevel != nil && strings.EqualFold(*a.ConditionLevel, "LOW") {
+		return gpio.Low
+	}
+	return gpio.High
+}
+
+// conditionCheck publishes the gate state of the appliance whenever the condition pin changes
+func conditionCheck(ctx context.Context, in gpio.Input, a pi.ApplianceData, c publisher) {
+	c.PublishGate(mqtt.Gate{ApplianceID: a.ID, Inhibited: in.Read() == inhibitLevel(a), Timestamp: time.Now()})
+	err := in.Watch(ctx, func(level gpio.Level) {
+		c.PublishGate(mqtt.Gate{ApplianceID: a.ID, Inhibited: level == inhibitLevel(a), Timestamp: time.Now()})
+	})
+	log.Printf("Stopped watching condition of %s: %v", a.Name, err)
+}
+
+// availabilityHandler lights the availability LED while control-remo is online and blinks it while offline,
+// pin is nil without an availability LED
+type availabilityHandler struct {
+	pin    gpio.Output
+	online chan bool
+}
+
+func newAvailabilityHandler(pin gpio.Output) *availabilityHandler {
+	return &availabilityHandler{pin: pin, online: make(chan bool, 1)}
+}
+
+func (h *availabilityHandler) HandleAvailability(online bool) error {
+	fmt.Println("control-remo online:", online)
+	health.update(func(h *healthState) { h.remo = online })
+	if h.pin == nil {
+		return nil
+	}
+	select {
+	case h.online <- online:
+	default:
+		// The runner has not picked up the previous state yet, replace it
+		select {
+		case <-h.online:
+		default:
 		}
+		h.online <- online
+	}
+	return nil
+}
+
+func (h *availabilityHandler) run(ctx context.Context) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	online := false
+	level := gpio.High
+	for {
+		select {
+		case online = <-h.online:
+			if online {
+				level = gpio.Low
+				h.pin.Write(level)
+			}
+		case <-ticker.C:
+			if !online {
+				level = !level
+				h.pin.Write(level)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resultHandler blinks the error LED when a command sent from this panel fails
+type resultHandler struct {
+	mu      sync.Mutex
+	pending map[string]time.Time // request ID to the time the command was sent
+}
+
+// add returns a new request ID for a command to the appliance and waits for its result
+func (h *resultHandler) add(applianceID string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	// Forget requests whose result never arrived
+	for id, sent := range h.pending {
+		if now.Sub(sent) > time.Minute {
+			delete(h.pending, id)
+		}
+	}
+	id := fmt.Sprintf("gpio-%s-%d", applianceID, now.UnixNano())
+	h.pending[id] = now
+	if l, ok := statusLEDs[applianceID]; ok {
+		l.sent()
+	}
+	return id
+}
+
+func (h *resultHandler) HandleResult(result mqtt.Result) error {
+	h.mu.Lock()
+	_, ok := h.pending[result.RequestID]
+	delete(h.pending, result.RequestID)
+	h.mu.Unlock()
+	if !ok {
+		// Sent by another client
+		return nil
+	}
+	if l, ok := statusLEDs[result.ApplianceID]; ok {
+		l.done(result.Success)
+	}
+	if result.Success {
+		return nil
+	}
+
+	fmt.Println("command failed:", result.ApplianceID, result.Button, result.Error)
+	if config.ErrorPin != nil {
+		out, err := output(*config.ErrorPin)
+		if err != nil {
+			return err
+		}
+		go blink(out, 5, 100*time.Millisecond)
+	}
+	return nil
+}
+
+// blink flashes the active-low LED on pin n times
+func blink(pin gpio.Output, n int, interval time.Duration) {
+	for i := 0; i < n; i++ {
+		pin.Write(gpio.Low)
+		time.Sleep(interval)
+		pin.Write(gpio.High)
+		time.Sleep(interval)
 	}
 }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cormoran/natureremo"
+	pi "github.com/eivy/control-remo-from-pi"
+	"github.com/eivy/control-remo-from-pi/gpio"
+	"github.com/eivy/control-remo-from-pi/mqtt"
+)
+
+// fakePublisher records what the panel publishes
+type fakePublisher struct {
+	mu       sync.Mutex
+	commands []mqtt.Command
+	gates    []mqtt.Gate
+	statuses []mqtt.Status
+	offline  bool
+}
+
+func (p *fakePublisher) PublishStatus(status mqtt.Status) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statuses = append(p.statuses, status)
+	return nil
+}
+
+func (p *fakePublisher) IsConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.offline
+}
+
+func (p *fakePublisher) PublishCommand(cmd mqtt.Command) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commands = append(p.commands, cmd)
+	return nil
+}
+
+func (p *fakePublisher) PublishGate(gate mqtt.Gate) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gates = append(p.gates, gate)
+	return nil
+}
+
+// waitCommands waits until n commands were published and returns them
+func (p *fakePublisher) waitCommands(t *testing.T, n int) []mqtt.Command {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		p.mu.Lock()
+		commands := append([]mqtt.Command(nil), p.commands...)
+		p.mu.Unlock()
+		if len(commands) >= n {
+			return commands
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d commands to be published", n)
+	return nil
+}
+
+// waitLevel waits until the LED animator drove the pin to the level
+func waitLevel(t *testing.T, sim *gpio.Sim, pin int, level gpio.Level) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if sim.Level(pin) == level {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected pin %d to be %s", pin, level)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// startPanel runs the panel on simulated pins with the appliances
+func startPanel(t *testing.T, appliances map[string]pi.ApplianceData) (*gpio.Sim, *fakePublisher) {
+	return startPanelWith(t, pi.Config{Appliances: appliances})
+}
+
+// startPanelWith runs the panel on simulated pins with the config
+func startPanelWith(t *testing.T, c pi.Config) (*gpio.Sim, *fakePublisher) {
+	sim := gpio.NewSim()
+	ctx, ch, p := startHandler(t, sim, c)
+	if err := watchAppliances(ctx, ch, p); err != nil {
+		t.Fatal(err)
+	}
+	// Drive the pins only once they are watched
+	var watched []int
+	for _, a := range c.Appliances {
+		for _, pin := range []*int{a.SwitchPin, a.ConditionPin} {
+			if pin != nil {
+				watched = append(watched, *pin)
+			}
+		}
+	}
+	for _, chord := range c.Chords {
+		watched = append(watched, chord.Pins...)
+	}
+	for _, pin := range watched {
+		for i := 0; !sim.Watched(pin); i++ {
+			if i == 1000 {
+				t.Fatalf("Expected pin %d to be watched", pin)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return sim, p
+}
+
+// startHandler sets the panel up with the config on the pins and runs the LED animator and the button
+// handler, which receives the returned channel, until the test ends; the next test may only replace the
+// panel state once they returned
+func startHandler(t *testing.T, b gpio.Backend, c pi.Config) (context.Context, chan switchEvent, *fakePublisher) {
+	pins = b
+	config = c
+	conditions = make(map[string]gpio.Input)
+	outputs = make(map[int]gpio.Output)
+	statusLEDs = make(map[string]*statusLED)
+	animator = gpio.NewAnimator()
+	p := &fakePublisher{}
+	if err := openStatusLEDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		running.Wait()
+	})
+	ch := make(chan switchEvent)
+	running.Add(2)
+	go func(a *gpio.Animator) {
+		defer running.Done()
+		a.Run(ctx)
+	}(animator)
+	go func() {
+		defer running.Done()
+		buttonHandler(ctx, ch, p)
+	}()
+	return ctx, ch, p
+}
+
+func TestTogglePress(t *testing.T) {
+	sim, p := startPanel(t, map[string]pi.ApplianceData{
+		"light": {ID: "light", Name: "Light", Trigger: pi.TriggerTOGGLE, SwitchPin: intPtr(17), StatusPin: intPtr(4)},
+	})
+
+	sim.Set(17, gpio.High)
+	sim.Set(17, gpio.Low)
+	sim.Set(17, gpio.High)
+
+	commands := p.waitCommands(t, 2)
+	for _, cmd := range commands {
+		if cmd.ApplianceID != "light" || cmd.Button != "toggle" || cmd.RequestID == "" {
+			t.Errorf("Unexpected command: %+v", cmd)
+		}
+	}
+
+	if err := (&MQTTStatusHandler{}).HandleStatus(mqtt.Status{ApplianceID: "light", PowerState: true}); err != nil {
+		t.Fatal(err)
+	}
+	waitLevel(t, sim, 4, gpio.Low)
+}
+
+func TestSyncSwitch(t *testing.T) {
+	sim, p := startPanel(t, map[string]pi.ApplianceData{
+		"fan": {ID: "fan", Name: "Fan", Trigger: pi.TriggerSYNC, SwitchPin: intPtr(17)},
+	})
+
+	// The startup reconciliation sends the initial position first
+	p.waitCommands(t, 1)
+	sim.Set(17, gpio.High)
+
+	commands := p.waitCommands(t, 2)
+	if commands[0].Button != "off" || commands[1].Button != "on" {
+		t.Errorf("Expected off then on, got %+v", commands)
+	}
+}
+
+func TestConditionRemap(t *testing.T) {
+	sim, p := startPanel(t, map[string]pi.ApplianceData{
+		"light": {
+			ID: "light", Name: "Light", Trigger: pi.TriggerTOGGLE, SwitchPin: intPtr(17),
+			ConditionPin: intPtr(27), ConditionButton: strPtr("night"),
+		},
+	})
+
+	sim.Set(27, gpio.High)
+	sim.Set(17, gpio.High)
+
+	commands := p.waitCommands(t, 1)
+	if commands[0].Button != "night" {
+		t.Errorf("Expected remapped button, got %+v", commands[0])
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, gate := range p.gates {
+		if gate.Suppressed != "" {
+			if !gate.Inhibited || gate.Suppressed != "toggle" || gate.Remapped != "night" {
+				t.Errorf("Unexpected gate: %+v", gate)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected the remapped press to be published as gate, got %+v", p.gates)
+}
+
+func TestGestures(t *testing.T) {
+	sim, p := startPanelWith(t, pi.Config{
+		Appliances: map[string]pi.ApplianceData{
+			"light": {
+				ID: "light", Name: "Light", Trigger: pi.TriggerTOGGLE, SwitchPin: intPtr(17),
+				Gestures: map[string]pi.Action{"LONG": {Button: "off", Appliances: []string{"light", "fan"}}},
+			},
+			"fan": {ID: "fan", Name: "Fan", Trigger: pi.TriggerTOGGLE, SwitchPin: intPtr(22)},
+		},
+		LongPress: 50 * time.Millisecond,
+	})
+
+	sim.Set(17, gpio.High)
+	time.Sleep(80 * time.Millisecond)
+	sim.Set(17, gpio.Low)
+
+	commands := p.waitCommands(t, 2)
+	for _, cmd := range commands {
+		if cmd.Button != "off" {
+			t.Errorf("Expected long press to send off to all, got %+v", cmd)
+		}
+	}
+}
+
+func TestChord(t *testing.T) {
+	light := pi.ApplianceData{ID: "light", Name: "Light", Trigger: pi.TriggerTOGGLE, SwitchPin: intPtr(17)}
+	fan := pi.ApplianceData{ID: "fan", Name: "Fan", Trigger: pi.TriggerTOGGLE, SwitchPin: intPtr(22)}
+	// Each pin is watched separately, so the events go to the handler directly to keep them in order
+	_, ch, p := startHandler(t, gpio.NewSim(), pi.Config{
+		Appliances: map[string]pi.ApplianceData{"light": light, "fan": fan},
+		Chords:     []pi.Chord{{Pins: []int{17, 22}, Action: pi.Action{Button: "off"}}},
+	})
+
+	for _, e := range []switchEvent{
+		{Pin: 17, Appliance: light, Level: gpio.High}, {Pin: 22, Appliance: fan, Level: gpio.High},
+		{Pin: 17, Appliance: light, Level: gpio.Low}, {Pin: 22, Appliance: fan, Level: gpio.Low},
+		// A single press of a chord pin still toggles, on release
+		{Pin: 17, Appliance: light, Level: gpio.High}, {Pin: 17, Appliance: light, Level: gpio.Low},
+	} {
+		ch <- e
+	}
+
+	// A stray toggle from the chord presses would be published before the last one
+	commands := p.waitCommands(t, 3)
+	if len(commands) != 3 {
+		t.Fatalf("Expected 3 commands, got %+v", commands)
+	}
+	buttons := map[string]string{}
+	for _, cmd := range commands[:2] {
+		buttons[cmd.ApplianceID] = cmd.Button
+	}
+	if buttons["light"] != "off" || buttons["fan"] != "off" {
+		t.Errorf("Expected chord to send off to both, got %+v", commands[:2])
+	}
+	if commands[2].ApplianceID != "light" || commands[2].Button != "toggle" {
+		t.Errorf("Expected toggle of light, got %+v", commands[2])
+	}
+}
+
+func TestStatusLEDOverlays(t *testing.T) {
+	l := &statusLED{config: pi.StatusLED{Error: "solid", Dim: true}}
+	now := time.Now()
+	l.on = true
+	l.brightness = intPtr(40)
+	if p, b := l.pattern(now); p != gpio.PatternSolid || b != 40 {
+		t.Errorf("Expected dimmed light, got %s at %d", p, b)
+	}
+	l.pendingUntil = now.Add(time.Second)
+	if p, _ := l.pattern(now); p != gpio.PatternBlink {
+		t.Errorf("Expected blink while pending, got %s", p)
+	}
+	l.errorUntil = now.Add(time.Second)
+	if p, b := l.pattern(now); p != gpio.PatternSolid || b != 100 {
+		t.Errorf("Expected configured error pattern, got %s at %d", p, b)
+	}
+	health.update(func(h *healthState) { h.api = false })
+	defer health.update(func(h *healthState) { h.api = true })
+	if p, _ := l.pattern(now); p != gpio.PatternPulse {
+		t.Errorf("Expected pulse while unreachable, got %s", p)
+	}
+}
+
+// fakeSender records the buttons sent to an appliance, and how many were sent at once with the others
+type fakeSender struct {
+	mu      sync.Mutex
+	buttons []string
+}
+
+// sending counts the sends in progress on every fakeSender, overlapping is the highest count seen
+var sending, overlapping atomic.Int32
+
+func (s *fakeSender) send(button string) (*natureremo.LightState, error) {
+	n := sending.Add(1)
+	defer sending.Add(-1)
+	for {
+		seen := overlapping.Load()
+		if n <= seen || overlapping.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+	// Long enough for another send to start if sends were concurrent
+	time.Sleep(5 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buttons = append(s.buttons, button)
+	return &natureremo.LightState{Power: button}, nil
+}
+
+func (s *fakeSender) On(ctx context.Context) (*natureremo.LightState, error) {
+	return s.send("on")
+}
+
+func (s *fakeSender) Off(ctx context.Context) (*natureremo.LightState, error) {
+	return s.send("off")
+}
+
+func (s *fakeSender) Send(ctx context.Context, button string) (*natureremo.LightState, error) {
+	return s.send(button)
+}
+
+func TestFallbackDirect(t *testing.T) {
+	sender := &fakeSender{}
+	config = pi.Config{Appliances: map[string]pi.ApplianceData{
+		"light": {ID: "light", Name: "Light", Type: pi.ApplianceTypeLight, Sender: sender},
+	}}
+	statusLEDs = make(map[string]*statusLED)
+	b := &fakePublisher{offline: true}
+	f := newFallbackPublisher(b, true)
+	f.remember(mqtt.Status{ApplianceID: "light", PowerState: true})
+
+	if err := f.PublishCommand(mqtt.Command{ApplianceID: "light", Button: "toggle", RequestID: results.add("light")}); err != nil {
+		t.Fatal(err)
+	}
+	f.running.Wait()
+	sender.mu.Lock()
+	buttons := sender.buttons
+	sender.mu.Unlock()
+	if len(buttons) != 1 || buttons[0] != "off" {
+		t.Fatalf("Expected the light to be switched off directly, got %v", buttons)
+	}
+	b.mu.Lock()
+	commands := b.commands
+	b.offline = false
+	b.mu.Unlock()
+	if len(commands) != 0 {
+		t.Errorf("Expected nothing to be published while offline, got %+v", commands)
+	}
+
+	f.resync()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.statuses) != 1 || b.statuses[0].ApplianceID != "light" || b.statuses[0].PowerState {
+		t.Errorf("Expected the off state to be resynced, got %+v", b.statuses)
+	}
+}
+
+func TestFallbackDirectOneAtATime(t *testing.T) {
+	light, fan := &fakeSender{}, &fakeSender{}
+	config = pi.Config{Appliances: map[string]pi.ApplianceData{
+		"light": {ID: "light", Name: "Light", Type: pi.ApplianceTypeLight, Sender: light},
+		"fan":   {ID: "fan", Name: "Fan", Type: pi.ApplianceTypeLight, Sender: fan},
+	}}
+	statusLEDs = make(map[string]*statusLED)
+	f := newFallbackPublisher(&fakePublisher{offline: true}, true)
+	overlapping.Store(0)
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"light", "fan"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f.PublishCommand(mqtt.Command{ApplianceID: id, Button: "on", RequestID: results.add(id)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	f.running.Wait()
+
+	for name, s := range map[string]*fakeSender{"light": light, "fan": fan} {
+		s.mu.Lock()
+		if len(s.buttons) != 1 {
+			t.Errorf("Expected one send to %s, got %v", name, s.buttons)
+		}
+		s.mu.Unlock()
+	}
+	if n := overlapping.Load(); n != 1 {
+		t.Errorf("Expected direct sends one at a time, %d overlapped", n)
+	}
+}
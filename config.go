@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"time"
 
@@ -17,6 +18,32 @@ type Config struct {
 	Appliances    map[string]ApplianceData `yaml:"Appliances"`
 	CheckInterval time.Duration            `yaml:"CeckInterval"`
 	Server        *Server                  `yaml:"Server"`
+	// AvailabilityPin shows whether control-remo is online, blinking while it is offline
+	AvailabilityPin *int `yaml:"AvailabilityPin"`
+	// ErrorPin blinks when a command sent from this panel fails
+	ErrorPin *int `yaml:"ErrorPin"`
+	// GPIOChip is the gpio character device delivering edge events, "/dev/gpiochip0" if empty
+	GPIOChip string `yaml:"GPIOChip"`
+	// GPIOBackend is "rpio", "cdev" or "sim", by default the character device falling back to rpio
+	GPIOBackend string `yaml:"GPIOBackend"`
+	// GPIOSim drives simulated inputs from "file:/path" or a socket, "unix:/path" or "host:port"
+	GPIOSim string `yaml:"GPIOSim"`
+	// Chords are actions for switch pins pressed together
+	Chords []Chord `yaml:"Chords"`
+	// LongPress, DoublePress and HoldRepeat tune the gestures, see the defaults in package gpio
+	LongPress   time.Duration `yaml:"LongPress"`
+	DoublePress time.Duration `yaml:"DoublePress"`
+	HoldRepeat  time.Duration `yaml:"HoldRepeat"`
+	// StateFile keeps states, timers and command history of control-remo across restarts, nothing is kept if empty
+	StateFile string `yaml:"StateFile"`
+	// Retry is the retry policy of sends failing transiently, see the defaults in RetryPolicy
+	Retry RetryPolicy `yaml:"Retry"`
+	// Breaker tunes the circuit breakers of the Remo devices
+	Breaker BreakerConfig `yaml:"Breaker"`
+	// StatusSync republishes the known states to MQTT on this interval, only after connecting to the broker if 0
+	StatusSync time.Duration `yaml:"StatusSync"`
+	// Tariffs price the consumption of smart meters by time of day
+	Tariffs []Tariff `yaml:"Tariffs"`
 }
 
 // ReadConfig returns config read from config file which is in excute path or specified in command args
@@ -34,39 +61,93 @@ func ReadConfig() (config Config, err error) {
 	}
 	var tmp struct {
 		Appliances map[string]struct {
-			ID           string              `yaml:"ID"`
-			Name         string              `yaml:"Name"`
-			Type         ApplianceType       `yaml:"Type"`
-			SwitchPin    *int                `yaml:"SwitchPin"`
-			StatusPin    *int                `yaml:"StatusPin"`
-			Trigger      Trigger             `yaml:"Trigger"`
-			Timer        *string             `yaml:"Timer"`
-			ConditionPin *int                `yaml:"ConditionPin"`
-			OnButton     *string             `yaml:"OnButton"`
-			OffButton    *string             `yaml:"OffButton"`
-			Status       *bool               // true is power on
-			IP           string              `yaml:"IP"`
-			OnLocal      natureremo.IRSignal `yaml:"OnLocal"`
-			OffLocal     natureremo.IRSignal `yaml:"OffLocal"`
-			OnSignal     string              `yaml:"OnSignal"`
-			OffSignal    string              `yaml:"OffSignal"`
+			ID              string                   `yaml:"ID"`
+			Name            string                   `yaml:"Name"`
+			Type            ApplianceType            `yaml:"Type"`
+			Topic           *string                  `yaml:"Topic"`
+			SwitchPin       *int                     `yaml:"SwitchPin"`
+			Pull            *string                  `yaml:"Pull"`
+			Debounce        *string                  `yaml:"Debounce"`
+			StatusPin       *int                     `yaml:"StatusPin"`
+			StatusLED       *StatusLED               `yaml:"StatusLED"`
+			Trigger         Trigger                  `yaml:"Trigger"`
+			Timer           *string                  `yaml:"Timer"`
+			ConditionPin    *int                     `yaml:"ConditionPin"`
+			ConditionLevel  *string                  `yaml:"ConditionLevel"`
+			ConditionButton *string                  `yaml:"ConditionButton"`
+			Gestures        map[string]Action        `yaml:"Gestures"`
+			Device          *string                  `yaml:"Device"`
+			Retry           *RetryPolicy             `yaml:"Retry"`
+			OnButton        *string                  `yaml:"OnButton"`
+			OffButton       *string                  `yaml:"OffButton"`
+			Status          *bool                    // true is power on
+			IP              string                   `yaml:"IP"`
+			OnLocal         natureremo.IRSignal      `yaml:"OnLocal"`
+			OffLocal        natureremo.IRSignal      `yaml:"OffLocal"`
+			Prefer          string                   `yaml:"Prefer"` // "cloud" (default) or "local" with IP of another type
+			OnSignal        string                   `yaml:"OnSignal"`
+			OffSignal       string                   `yaml:"OffSignal"`
+			Temperature     string                   `yaml:"Temperature"`
+			OperationMode   natureremo.OperationMode `yaml:"OperationMode"`
+			AirVolume       natureremo.AirVolume     `yaml:"AirVolume"`
+			AirDirection    natureremo.AirDirection  `yaml:"AirDirection"`
 		} `yaml:"Appliances"`
-		CheckInterval time.Duration `yaml:"CeckInterval"`
-		Server        *Server       `yaml:"Server"`
+		CheckInterval   time.Duration `yaml:"CeckInterval"`
+		Server          *Server       `yaml:"Server"`
+		AvailabilityPin *int          `yaml:"AvailabilityPin"`
+		ErrorPin        *int          `yaml:"ErrorPin"`
+		GPIOChip        string        `yaml:"GPIOChip"`
+		GPIOBackend     string        `yaml:"GPIOBackend"`
+		GPIOSim         string        `yaml:"GPIOSim"`
+		Chords          []Chord       `yaml:"Chords"`
+		LongPress       time.Duration `yaml:"LongPress"`
+		DoublePress     time.Duration `yaml:"DoublePress"`
+		HoldRepeat      time.Duration `yaml:"HoldRepeat"`
+		StateFile       string        `yaml:"StateFile"`
+		Retry           RetryPolicy   `yaml:"Retry"`
+		Breaker         BreakerConfig `yaml:"Breaker"`
+		StatusSync      time.Duration `yaml:"StatusSync"`
+		Tariffs         []Tariff      `yaml:"Tariffs"`
 	}
 	err = yaml.Unmarshal(b, &tmp)
+	for _, t := range tmp.Tariffs {
+		if err := t.validate(); err != nil {
+			return config, fmt.Errorf("invalid tariff %q: %v", t.Band, err)
+		}
+	}
 	appliances := make(map[string]ApplianceData)
 	fmt.Println("reading config", len(tmp.Appliances))
 	for k, v := range tmp.Appliances {
+		if l := v.ConditionLevel; l != nil && !strings.EqualFold(*l, "HIGH") && !strings.EqualFold(*l, "LOW") {
+			return config, fmt.Errorf("invalid ConditionLevel %q of %s, use HIGH or LOW", *l, k)
+		}
+		retry := v.Retry
+		if retry == nil {
+			retry = &tmp.Retry
+		}
+		device := v.Device
+		if device == nil && v.IP != "" {
+			// Appliances falling back to the local API keep working while the Cloud API is down
+			device = &v.IP
+		}
 		tmp := ApplianceData{
-			ID:           v.ID,
-			Name:         v.Name,
-			Type:         v.Type,
-			SwitchPin:    v.SwitchPin,
-			StatusPin:    v.StatusPin,
-			Trigger:      v.Trigger,
-			Timer:        v.Timer,
-			ConditionPin: v.ConditionPin,
+			ID:              v.ID,
+			Name:            v.Name,
+			Type:            v.Type,
+			Topic:           v.Topic,
+			SwitchPin:       v.SwitchPin,
+			Pull:            v.Pull,
+			Debounce:        v.Debounce,
+			StatusPin:       v.StatusPin,
+			StatusLED:       v.StatusLED,
+			Trigger:         v.Trigger,
+			Timer:           v.Timer,
+			ConditionPin:    v.ConditionPin,
+			ConditionLevel:  v.ConditionLevel,
+			ConditionButton: v.ConditionButton,
+			Gestures:        v.Gestures,
+			Device:          device,
+			Retry:           retry,
 		}
 		switch v.Type {
 		case ApplianceTypeIR:
@@ -91,6 +172,14 @@ func ReadConfig() (config Config, err error) {
 				OnLocal:       v.OnLocal,
 				OffLocal:      v.OffLocal,
 			}
+		case ApplianceTypeAirCon:
+			tmp.Sender = &ApplianceAirCon{
+				ApplianceData: tmp,
+				Temperature:   v.Temperature,
+				OperationMode: v.OperationMode,
+				AirVolume:     v.AirVolume,
+				AirDirection:  v.AirDirection,
+			}
 		case ApplianceTypeTV:
 			tmp.Sender = ApplianceTV{
 				ApplianceData: tmp,
@@ -98,12 +187,46 @@ func ReadConfig() (config Config, err error) {
 				OffButton:     v.OffButton,
 			}
 		}
+		if v.Type != ApplianceTypeLocal && v.IP != "" && tmp.Sender != nil {
+			// Signals declared for both APIs keep the appliance working while one of them is down
+			tmp.Sender = newFallback(tmp.Sender, ApplianceLocal{
+				ApplianceData: tmp,
+				IP:            v.IP,
+				OnLocal:       v.OnLocal,
+				OffLocal:      v.OffLocal,
+			}, v.Prefer)
+		}
 		appliances[k] = tmp
 	}
 	config = Config{
-		Server:        tmp.Server,
-		CheckInterval: tmp.CheckInterval,
-		Appliances:    appliances,
+		Server:          tmp.Server,
+		CheckInterval:   tmp.CheckInterval,
+		Appliances:      appliances,
+		AvailabilityPin: tmp.AvailabilityPin,
+		ErrorPin:        tmp.ErrorPin,
+		GPIOChip:        tmp.GPIOChip,
+		GPIOBackend:     tmp.GPIOBackend,
+		GPIOSim:         tmp.GPIOSim,
+		Chords:          tmp.Chords,
+		LongPress:       tmp.LongPress,
+		DoublePress:     tmp.DoublePress,
+		HoldRepeat:      tmp.HoldRepeat,
+		StateFile:       tmp.StateFile,
+		Retry:           tmp.Retry,
+		Breaker:         tmp.Breaker,
+		StatusSync:      tmp.StatusSync,
+		Tariffs:         tmp.Tariffs,
 	}
 	return
 }
+
+// Topics returns the MQTT topic names of appliances which are configured to use one instead of their ID
+func (c Config) Topics() map[string]string {
+	topics := make(map[string]string)
+	for id, a := range c.Appliances {
+		if a.Topic != nil {
+			topics[id] = *a.Topic
+		}
+	}
+	return topics
+}
@@ -0,0 +1,50 @@
+package controlremo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cormoran/natureremo"
+)
+
+// Execute sends the button to the appliance; "on" and "off" switch its power and "toggle" switches a light
+// or air conditioner to the opposite of poweredOn, other appliances receive "toggle" as a button
+func Execute(ctx context.Context, a ApplianceData, button string, poweredOn bool) (*natureremo.LightState, error) {
+	if a.Sender == nil {
+		return nil, fmt.Errorf("appliance %s of type %s cannot send buttons", a.ID, a.Type)
+	}
+	switch button {
+	case "on":
+		return powerOn(ctx, a)
+	case "off":
+		return powerOff(ctx, a)
+	case "toggle":
+		if a.Type != ApplianceTypeLight && a.Type != ApplianceTypeAirCon {
+			return a.Sender.Send(ctx, "toggle")
+		}
+		if poweredOn {
+			return powerOff(ctx, a)
+		}
+		return powerOn(ctx, a)
+	default:
+		return a.Sender.Send(ctx, button)
+	}
+}
+
+func powerOn(ctx context.Context, a ApplianceData) (*natureremo.LightState, error) {
+	switch a.Type {
+	case ApplianceTypeLight, ApplianceTypeLocal, ApplianceTypeAirCon:
+		return a.Sender.On(ctx)
+	default:
+		return a.Sender.Send(ctx, "on")
+	}
+}
+
+func powerOff(ctx context.Context, a ApplianceData) (*natureremo.LightState, error) {
+	switch a.Type {
+	case ApplianceTypeLight, ApplianceTypeLocal, ApplianceTypeAirCon:
+		return a.Sender.Off(ctx)
+	default:
+		return a.Sender.Send(ctx, "off")
+	}
+}
@@ -0,0 +1,14 @@
+package controlremo
+
+// Action is a button sent to appliances when a gesture or chord is recognized
+type Action struct {
+	Button string `yaml:"Button"`
+	// Appliances are the IDs of the appliances to send the button to, the pressed appliance if empty
+	Appliances []string `yaml:"Appliances"`
+}
+
+// Chord is an action for switch pins pressed together
+type Chord struct {
+	Pins   []int `yaml:"Pins"`
+	Action `yaml:",inline"`
+}
@@ -0,0 +1,208 @@
+package gpio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// GPIO v2 uAPI of the gpio character device, see linux/gpio.h
+const (
+	gpioV2LinesMax       = 64
+	gpioV2LineAttrsMax   = 10
+	gpioMaxNameSize      = 32
+	gpioV2GetLineIoctl   = 0xc250b407 // _IOWR(0xB4, 0x07, struct gpio_v2_line_request)
+	gpioV2GetValuesIoctl = 0xc010b40e // _IOWR(0xB4, 0x0E, struct gpio_v2_line_values)
+	gpioV2SetValuesIoctl = 0xc010b40f // _IOWR(0xB4, 0x0F, struct gpio_v2_line_values)
+
+	gpioV2LineFlagInput        = 1 << 2
+	gpioV2LineFlagOutput       = 1 << 3
+	gpioV2LineFlagEdgeRising   = 1 << 4
+	gpioV2LineFlagEdgeFalling  = 1 << 5
+	gpioV2LineFlagBiasPullUp   = 1 << 8
+	gpioV2LineFlagBiasPullDown = 1 << 9
+	gpioV2LineFlagBiasDisabled = 1 << 10
+
+	gpioV2LineAttrIDDebounce = 3
+
+	gpioV2LineEventRisingEdge = 1
+	gpioV2LineEventSize       = 48
+)
+
+type gpioV2LineConfigAttribute struct {
+	ID      uint32
+	Padding uint32
+	Value   uint64 // flags, values or debounce_period_us
+	Mask    uint64
+}
+
+type gpioV2LineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [gpioV2LineAttrsMax]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	Offsets         [gpioV2LinesMax]uint32
+	Consumer        [gpioMaxNameSize]byte
+	Config          gpioV2LineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	Fd              int32
+}
+
+type gpioV2LineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+// Cdev requests lines from a gpio character device, inputs deliver kernel edge events
+type Cdev struct {
+	chip string
+}
+
+// NewCdev checks that the chip, "/dev/gpiochip0" if empty, can be opened
+func NewCdev(chip string) (Backend, error) {
+	if chip == "" {
+		chip = "/dev/gpiochip0"
+	}
+	f, err := os.Open(chip)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &Cdev{chip: chip}, nil
+}
+
+func (b *Cdev) Input(pin int, pull Pull, debounce time.Duration) (Input, error) {
+	var config gpioV2LineConfig
+	config.Flags = gpioV2LineFlagInput | gpioV2LineFlagEdgeRising | gpioV2LineFlagEdgeFalling
+	switch pull {
+	case PullUp:
+		config.Flags |= gpioV2LineFlagBiasPullUp
+	case PullDown:
+		config.Flags |= gpioV2LineFlagBiasPullDown
+	case PullNone:
+		config.Flags |= gpioV2LineFlagBiasDisabled
+	}
+	if debounce > 0 {
+		config.NumAttrs = 1
+		config.Attrs[0] = gpioV2LineConfigAttribute{
+			ID:    gpioV2LineAttrIDDebounce,
+			Value: uint64(debounce.Microseconds()),
+			Mask:  1,
+		}
+	}
+	return b.request(pin, config)
+}
+
+func (b *Cdev) Output(pin int) (Output, error) {
+	return b.request(pin, gpioV2LineConfig{Flags: gpioV2LineFlagOutput})
+}
+
+// Close does nothing, every line is released with its own file
+func (b *Cdev) Close() error {
+	return nil
+}
+
+func (b *Cdev) request(pin int, config gpioV2LineConfig) (*line, error) {
+	c, err := os.Open(b.chip)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var req gpioV2LineRequest
+	req.Offsets[0] = uint32(pin)
+	req.NumLines = 1
+	copy(req.Consumer[:], "control-remo")
+	req.Config = config
+
+	if err := ioctl(c.Fd(), gpioV2GetLineIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("failed to request line %d of %s: %v", pin, b.chip, err)
+	}
+	// Non-blocking so that closing the file interrupts a pending read
+	if err := unix.SetNonblock(int(req.Fd), true); err != nil {
+		unix.Close(int(req.Fd))
+		return nil, err
+	}
+	return &line{f: os.NewFile(uintptr(req.Fd), fmt.Sprintf("%s:%d", b.chip, pin))}, nil
+}
+
+// line is a single line requested from the gpio character device
+type line struct {
+	f *os.File
+}
+
+func (l *line) Read() Level {
+	values := gpioV2LineValues{Mask: 1}
+	if err := l.ioctl(gpioV2GetValuesIoctl, unsafe.Pointer(&values)); err != nil {
+		return Low
+	}
+	return values.Bits&1 == 1
+}
+
+func (l *line) Write(level Level) {
+	values := gpioV2LineValues{Mask: 1}
+	if level {
+		values.Bits = 1
+	}
+	l.ioctl(gpioV2SetValuesIoctl, unsafe.Pointer(&values))
+}
+
+// ioctl calls req on the line without f.Fd, which would put the file back into blocking mode
+func (l *line) ioctl(req uintptr, arg unsafe.Pointer) error {
+	conn, err := l.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ierr error
+	if err := conn.Control(func(fd uintptr) {
+		ierr = ioctl(fd, req, arg)
+	}); err != nil {
+		return err
+	}
+	return ierr
+}
+
+func (l *line) Watch(ctx context.Context, handle func(Level)) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.f.Close()
+	})
+	defer stop()
+
+	buf := make([]byte, gpioV2LineEventSize*16)
+	before := l.Read()
+	for {
+		n, err := l.f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for i := 0; i+gpioV2LineEventSize <= n; i += gpioV2LineEventSize {
+			// struct gpio_v2_line_event: timestamp_ns u64, id u32, ...
+			tmp := binary.LittleEndian.Uint32(buf[i+8:]) == gpioV2LineEventRisingEdge
+			if Level(tmp) != before {
+				handle(Level(tmp))
+				before = Level(tmp)
+			}
+		}
+	}
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
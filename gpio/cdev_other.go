@@ -0,0 +1,10 @@
+//go:build !linux
+
+package gpio
+
+import "errors"
+
+// NewCdev fails, the gpio character device is only available on linux
+func NewCdev(chip string) (Backend, error) {
+	return nil, errors.New("gpio character device is only available on linux")
+}
@@ -0,0 +1,153 @@
+package gpio
+
+import (
+	"sync"
+	"time"
+)
+
+// Gesture is a way of pressing a button
+type Gesture string
+
+const (
+	GesturePress  Gesture = "PRESS"  // short press
+	GestureLong   Gesture = "LONG"   // held past the long press time
+	GestureDouble Gesture = "DOUBLE" // two short presses in quick succession
+	GestureHold   Gesture = "HOLD"   // repeated while held past the long press time
+)
+
+// Default gesture timings
+const (
+	DefaultLongPress   = 800 * time.Millisecond
+	DefaultDoublePress = 300 * time.Millisecond
+	DefaultHoldRepeat  = 300 * time.Millisecond
+)
+
+// RecognizerOptions selects the gestures to tell apart and their timings
+type RecognizerOptions struct {
+	// Double delays short presses by DoublePress to detect double presses
+	Double bool
+	// Hold repeats HOLD while held instead of reporting LONG once
+	Hold        bool
+	LongPress   time.Duration
+	DoublePress time.Duration
+	HoldRepeat  time.Duration
+}
+
+// Recognizer turns the level changes of a button, pressed when high, into gestures
+type Recognizer struct {
+	opts RecognizerOptions
+	emit func(Gesture)
+
+	mu       sync.Mutex
+	pressed  bool
+	waiting  bool        // a short press waits for a possible second one
+	second   bool        // the current press follows a short one closely
+	long     bool        // the current press already reported LONG or HOLD
+	canceled bool        // the current press belongs to something else, e.g. a chord
+	timer    *time.Timer // long press, hold repeat or double press timeout
+	presses  int         // counts presses so that stale timers are ignored
+}
+
+// NewRecognizer calls emit with every gesture recognized from the levels passed to Handle
+func NewRecognizer(opts RecognizerOptions, emit func(Gesture)) *Recognizer {
+	if opts.LongPress <= 0 {
+		opts.LongPress = DefaultLongPress
+	}
+	if opts.DoublePress <= 0 {
+		opts.DoublePress = DefaultDoublePress
+	}
+	if opts.HoldRepeat <= 0 {
+		opts.HoldRepeat = DefaultHoldRepeat
+	}
+	return &Recognizer{opts: opts, emit: emit}
+}
+
+// Handle feeds a level change of the button
+func (r *Recognizer) Handle(level Level) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if level == High {
+		r.press()
+	} else {
+		r.release()
+	}
+}
+
+// Cancel drops the current press and any short press waiting for a second one
+func (r *Recognizer) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stop()
+	r.waiting = false
+	r.second = false
+	r.canceled = r.pressed
+}
+
+func (r *Recognizer) press() {
+	if r.pressed {
+		return
+	}
+	r.stop()
+	r.second = r.waiting
+	r.waiting = false
+	r.pressed = true
+	r.long = false
+	r.canceled = false
+	r.presses++
+	press := r.presses
+	r.timer = time.AfterFunc(r.opts.LongPress, func() { r.longPress(press) })
+}
+
+func (r *Recognizer) release() {
+	if !r.pressed {
+		return
+	}
+	r.pressed = false
+	r.stop()
+	switch {
+	case r.long || r.canceled:
+	case r.second:
+		r.second = false
+		go r.emit(GestureDouble)
+	case r.opts.Double:
+		r.waiting = true
+		press := r.presses
+		r.timer = time.AfterFunc(r.opts.DoublePress, func() { r.doubleTimeout(press) })
+	default:
+		go r.emit(GesturePress)
+	}
+}
+
+func (r *Recognizer) longPress(press int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if press != r.presses || !r.pressed || r.canceled {
+		return
+	}
+	r.long = true
+	r.second = false
+	if r.opts.Hold {
+		go r.emit(GestureHold)
+		r.timer = time.AfterFunc(r.opts.HoldRepeat, func() { r.longPress(press) })
+		return
+	}
+	go r.emit(GestureLong)
+}
+
+func (r *Recognizer) doubleTimeout(press int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if press != r.presses || !r.waiting {
+		return
+	}
+	r.waiting = false
+	r.timer = nil
+	go r.emit(GesturePress)
+}
+
+func (r *Recognizer) stop() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
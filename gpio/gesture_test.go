@@ -0,0 +1,94 @@
+package gpio
+
+import (
+	"testing"
+	"time"
+)
+
+var testTimings = RecognizerOptions{
+	LongPress:   50 * time.Millisecond,
+	DoublePress: 30 * time.Millisecond,
+	HoldRepeat:  20 * time.Millisecond,
+}
+
+// recognize feeds the levels with the given pause after each and returns the gestures seen afterwards
+func recognize(opts RecognizerOptions, presses ...time.Duration) []Gesture {
+	gestures := make(chan Gesture, 100)
+	r := NewRecognizer(opts, func(g Gesture) { gestures <- g })
+	level := High
+	for _, d := range presses {
+		r.Handle(level)
+		level = !level
+		time.Sleep(d)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var seen []Gesture
+	for {
+		select {
+		case g := <-gestures:
+			seen = append(seen, g)
+		default:
+			return seen
+		}
+	}
+}
+
+func TestRecognizer(t *testing.T) {
+	double := testTimings
+	double.Double = true
+	hold := testTimings
+	hold.Hold = true
+
+	tests := []struct {
+		name    string
+		opts    RecognizerOptions
+		presses []time.Duration // pause after each press and release
+		want    Gesture
+		min     int
+	}{
+		{"press", testTimings, []time.Duration{10 * time.Millisecond, 0}, GesturePress, 1},
+		{"long", testTimings, []time.Duration{80 * time.Millisecond, 0}, GestureLong, 1},
+		{"double", double, []time.Duration{5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond, 0}, GestureDouble, 1},
+		{"slow double", double, []time.Duration{5 * time.Millisecond, 60 * time.Millisecond, 5 * time.Millisecond, 0}, GesturePress, 2},
+		{"hold", hold, []time.Duration{120 * time.Millisecond, 0}, GestureHold, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := recognize(tt.opts, tt.presses...)
+			if len(seen) < tt.min {
+				t.Fatalf("Expected at least %d gestures, got %v", tt.min, seen)
+			}
+			for _, g := range seen {
+				if g != tt.want {
+					t.Errorf("Expected only %s, got %v", tt.want, seen)
+				}
+			}
+		})
+	}
+}
+
+func TestRecognizerCancel(t *testing.T) {
+	gestures := make(chan Gesture, 1)
+	r := NewRecognizer(testTimings, func(g Gesture) { gestures <- g })
+	r.Handle(High)
+	r.Cancel()
+	r.Handle(Low)
+	time.Sleep(80 * time.Millisecond)
+	select {
+	case g := <-gestures:
+		t.Errorf("Expected canceled press to be dropped, got %s", g)
+	default:
+	}
+
+	// The next press is recognized again
+	r.Handle(High)
+	r.Handle(Low)
+	select {
+	case g := <-gestures:
+		if g != GesturePress {
+			t.Errorf("Expected PRESS, got %s", g)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected PRESS after cancel")
+	}
+}
@@ -0,0 +1,127 @@
+// Package gpio abstracts the pins of the switch panel so that it can run on a Raspberry Pi,
+// on any Linux board with a gpio character device, or simulated on a laptop.
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Level is the electrical level of a pin
+type Level bool
+
+const (
+	Low  Level = false
+	High Level = true
+)
+
+func (l Level) String() string {
+	if l {
+		return "HIGH"
+	}
+	return "LOW"
+}
+
+// Pull is the bias of an input pin
+type Pull string
+
+const (
+	PullDefault Pull = ""
+	PullUp      Pull = "up"
+	PullDown    Pull = "down"
+	PullNone    Pull = "none"
+)
+
+// Input is a pin read from switches and contacts
+type Input interface {
+	Read() Level
+	// Watch calls handle on every debounced level change until ctx is done or the input fails
+	Watch(ctx context.Context, handle func(Level)) error
+}
+
+// Output is a pin driving a LED
+type Output interface {
+	Write(Level)
+}
+
+// PWMOutput is an output dimmed in hardware
+type PWMOutput interface {
+	Output
+	// Duty keeps the output high for the percentage of every PWM period
+	Duty(percent int)
+}
+
+// Backend opens pins
+type Backend interface {
+	Input(pin int, pull Pull, debounce time.Duration) (Input, error)
+	Output(pin int) (Output, error)
+	Close() error
+}
+
+// Backend names accepted by Open
+const (
+	BackendAuto = ""
+	BackendRPIO = "rpio"
+	BackendCdev = "cdev"
+	BackendSim  = "sim"
+)
+
+// Open returns the named backend; the automatic one uses the gpio character device
+// and falls back to rpio for pins or systems where it is unavailable
+func Open(name, chip string) (Backend, error) {
+	switch name {
+	case BackendRPIO:
+		return NewRPIO()
+	case BackendCdev:
+		return NewCdev(chip)
+	case BackendSim:
+		return NewSim(), nil
+	case BackendAuto:
+		cdev, cdevErr := NewCdev(chip)
+		rpio, rpioErr := NewRPIO()
+		switch {
+		case cdevErr != nil && rpioErr != nil:
+			return nil, fmt.Errorf("no GPIO backend available: %v, %v", cdevErr, rpioErr)
+		case cdevErr != nil:
+			log.Printf("GPIO character device unavailable, using rpio: %v", cdevErr)
+			return rpio, nil
+		case rpioErr != nil:
+			return cdev, nil
+		}
+		return &fallback{primary: cdev, secondary: rpio}, nil
+	}
+	return nil, fmt.Errorf("unknown GPIO backend: %s", name)
+}
+
+// fallback opens pins from primary, and from secondary where primary fails
+type fallback struct {
+	primary   Backend
+	secondary Backend
+}
+
+func (b *fallback) Input(pin int, pull Pull, debounce time.Duration) (Input, error) {
+	in, err := b.primary.Input(pin, pull, debounce)
+	if err == nil {
+		return in, nil
+	}
+	log.Printf("Edge events unavailable for pin %d, polling instead: %v", pin, err)
+	return b.secondary.Input(pin, pull, debounce)
+}
+
+func (b *fallback) Output(pin int) (Output, error) {
+	out, err := b.primary.Output(pin)
+	if err == nil {
+		return out, nil
+	}
+	return b.secondary.Output(pin)
+}
+
+func (b *fallback) Close() error {
+	err := b.primary.Close()
+	if err2 := b.secondary.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package gpio
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pattern is the way a LED is lit
+type Pattern string
+
+const (
+	PatternOff       Pattern = "off"
+	PatternSolid     Pattern = "solid"
+	PatternBlink     Pattern = "blink" // 2 Hz
+	PatternFastBlink Pattern = "fast"  // 10 Hz
+	PatternPulse     Pattern = "pulse" // fades in and out every 2 seconds
+)
+
+// Timings of the patterns and the software PWM, which dims at 100 Hz in steps of 20%
+const (
+	blinkPeriod     = 500 * time.Millisecond
+	fastBlinkPeriod = 100 * time.Millisecond
+	pulsePeriod     = 2 * time.Second
+	pwmPeriod       = 10 * time.Millisecond
+	pwmTick         = 2 * time.Millisecond
+	pwmSteps        = int(pwmPeriod / pwmTick)
+	idleTick        = 25 * time.Millisecond
+)
+
+// LED is an output animated by an Animator
+type LED struct {
+	animator  *Animator
+	out       Output
+	activeLow bool
+
+	mu         sync.Mutex
+	pattern    Pattern
+	brightness int // percent
+	lit        bool
+	written    bool
+	dutyOut    int // last written to a PWMOutput
+}
+
+// Set shows the pattern at the brightness in percent, dimmed below 100 by hardware PWM if the output
+// supports it and by software PWM otherwise
+func (l *LED) Set(p Pattern, brightness int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pattern = p
+	l.brightness = min(max(brightness, 0), 100)
+	l.animator.Refresh()
+}
+
+// duty returns the percentage of time the LED is lit at t since the animator started
+func (l *LED) duty(t time.Duration) int {
+	switch l.pattern {
+	case PatternSolid:
+		return l.brightness
+	case PatternBlink:
+		return blinkDuty(t, blinkPeriod, l.brightness)
+	case PatternFastBlink:
+		return blinkDuty(t, fastBlinkPeriod, l.brightness)
+	case PatternPulse:
+		// Triangle wave from off to the brightness and back
+		phase := int(t%pulsePeriod) * 200 / int(pulsePeriod)
+		if phase > 100 {
+			phase = 200 - phase
+		}
+		return l.brightness * phase / 100
+	}
+	return 0
+}
+
+func blinkDuty(t, period time.Duration, brightness int) int {
+	if t%period < period/2 {
+		return brightness
+	}
+	return 0
+}
+
+// update writes the level of the LED at t and returns whether it needs software PWM
+func (l *LED) update(t time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	duty := l.duty(t)
+	if out, ok := l.out.(PWMOutput); ok {
+		if l.activeLow {
+			duty = 100 - duty
+		}
+		if !l.written || duty != l.dutyOut {
+			out.Duty(duty)
+			l.dutyOut = duty
+			l.written = true
+		}
+		return false
+	}
+	// Lit for the first ticks of every period, rounded to the nearest step
+	lit := int(t%pwmPeriod/pwmTick) < (duty*pwmSteps+50)/100
+	if !l.written || lit != l.lit {
+		l.out.Write(Level(lit != l.activeLow))
+		l.lit = lit
+		l.written = true
+	}
+	return l.pattern == PatternPulse || (duty > 0 && duty < 100)
+}
+
+// Animator drives the patterns of its LEDs from a single goroutine
+type Animator struct {
+	mu   sync.Mutex
+	leds []*LED
+	wake chan struct{}
+}
+
+func NewAnimator() *Animator {
+	return &Animator{wake: make(chan struct{}, 1)}
+}
+
+// Add returns the LED on the output, initially off
+func (a *Animator) Add(out Output, activeLow bool) *LED {
+	l := &LED{animator: a, out: out, activeLow: activeLow, pattern: PatternOff}
+	a.mu.Lock()
+	a.leds = append(a.leds, l)
+	a.mu.Unlock()
+	a.Refresh()
+	return l
+}
+
+// Refresh makes the animator show pattern changes right away instead of on its next tick
+func (a *Animator) Refresh() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run animates the LEDs until ctx is done, ticking fast only while a LED needs PWM
+func (a *Animator) Run(ctx context.Context) {
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-a.wake:
+			// A tick left in the channel only brings the next update forward
+		case <-ctx.Done():
+			return
+		}
+		t := time.Since(start)
+		a.mu.Lock()
+		pwm := false
+		for _, l := range a.leds {
+			if l.update(t) {
+				pwm = true
+			}
+		}
+		a.mu.Unlock()
+		if pwm {
+			timer.Reset(pwmTick)
+		} else {
+			timer.Reset(idleTick)
+		}
+	}
+}
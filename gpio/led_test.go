@@ -0,0 +1,81 @@
+package gpio
+
+import (
+	"testing"
+	"time"
+)
+
+// recordOutput counts the time a LED is lit
+type recordOutput struct {
+	level Level
+}
+
+func (o *recordOutput) Write(level Level) {
+	o.level = level
+}
+
+// litPercent returns the percentage of PWM ticks the LED is lit during the window starting at t
+func litPercent(l *LED, out *recordOutput, start, window time.Duration) int {
+	lit, ticks := 0, 0
+	for t := start; t < start+window; t += pwmTick {
+		l.update(t)
+		if out.level == Low {
+			lit++
+		}
+		ticks++
+	}
+	return lit * 100 / ticks
+}
+
+func TestLEDPatterns(t *testing.T) {
+	a := NewAnimator()
+	out := &recordOutput{}
+	l := a.Add(out, true)
+
+	tests := []struct {
+		pattern    Pattern
+		brightness int
+		start      time.Duration
+		window     time.Duration
+		want       int
+	}{
+		{PatternOff, 100, 0, pwmPeriod, 0},
+		{PatternSolid, 100, 0, pwmPeriod, 100},
+		{PatternSolid, 30, 0, pwmPeriod, 40}, // rounded to a step
+		{PatternBlink, 100, 0, blinkPeriod / 2, 100},
+		{PatternBlink, 100, blinkPeriod / 2, blinkPeriod / 2, 0},
+		{PatternFastBlink, 60, 0, fastBlinkPeriod / 2, 60},
+		{PatternPulse, 100, pulsePeriod / 2, pwmTick, 100},
+		{PatternPulse, 100, 0, pwmTick, 0},
+	}
+	for _, tt := range tests {
+		l.Set(tt.pattern, tt.brightness)
+		if got := litPercent(l, out, tt.start, tt.window); got != tt.want {
+			t.Errorf("%s at %d%% from %v: lit %d%%, want %d%%", tt.pattern, tt.brightness, tt.start, got, tt.want)
+		}
+	}
+}
+
+// dutyOutput records the duty of a hardware PWM output
+type dutyOutput struct {
+	recordOutput
+	duty int
+}
+
+func (o *dutyOutput) Duty(percent int) {
+	o.duty = percent
+}
+
+func TestLEDHardwarePWM(t *testing.T) {
+	a := NewAnimator()
+	out := &dutyOutput{}
+	l := a.Add(out, true)
+
+	l.Set(PatternSolid, 30)
+	if l.update(0) {
+		t.Error("Expected no software PWM for a hardware PWM output")
+	}
+	if out.duty != 70 {
+		t.Errorf("duty = %d, want 70 for 30%% active low", out.duty)
+	}
+}
@@ -0,0 +1,134 @@
+package gpio
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	rpio "github.com/stianeikeland/go-rpio"
+)
+
+// pollInterval is how often rpio inputs are read
+const pollInterval = 10 * time.Millisecond
+
+// Hardware PWM of LEDs, cycles of 100 steps at 1 kHz
+const (
+	pwmCycle     = 100
+	pwmFrequency = 1000
+)
+
+// pwmChannels are the hardware PWM channels of the pins having one, every pin of a channel has its duty
+var pwmChannels = map[int]int{12: 0, 18: 0, 13: 1, 19: 1}
+
+// RPIO accesses the pins of a Raspberry Pi through /dev/gpiomem, polling inputs
+type RPIO struct {
+	mu      sync.Mutex
+	pwmUsed map[int]bool // by channel
+}
+
+// NewRPIO maps the GPIO registers of the Raspberry Pi
+func NewRPIO() (*RPIO, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, err
+	}
+	return &RPIO{pwmUsed: make(map[int]bool)}, nil
+}
+
+func (b *RPIO) Input(pin int, pull Pull, debounce time.Duration) (Input, error) {
+	p := rpio.Pin(pin)
+	p.Mode(rpio.Input)
+	switch pull {
+	case PullUp:
+		p.PullUp()
+	case PullDown:
+		p.PullDown()
+	case PullNone:
+		p.PullOff()
+	}
+	return &rpioInput{pin: p, debounce: debounce}, nil
+}
+
+// Output dims the first output of each hardware PWM channel with it, which needs root to take effect
+func (b *RPIO) Output(pin int) (Output, error) {
+	p := rpio.Pin(pin)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch, ok := pwmChannels[pin]; ok && !b.pwmUsed[ch] {
+		b.pwmUsed[ch] = true
+		p.Mode(rpio.Pwm)
+		p.Freq(pwmFrequency * pwmCycle)
+		p.DutyCycle(0, pwmCycle)
+		return rpioPWMOutput{pin: p}, nil
+	}
+	p.Mode(rpio.Output)
+	return rpioOutput{pin: p}, nil
+}
+
+func (b *RPIO) Close() error {
+	return rpio.Close()
+}
+
+// rpioInput reads the pin periodically, reporting a level once it was stable for the debounce time
+type rpioInput struct {
+	pin      rpio.Pin
+	debounce time.Duration
+}
+
+func (in *rpioInput) Read() Level {
+	return in.pin.Read() == rpio.High
+}
+
+func (in *rpioInput) Watch(ctx context.Context, handle func(Level)) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	before := in.Read()
+	var since time.Time // when the pin first differed from before
+	for {
+		tmp := in.Read()
+		switch {
+		case tmp == before:
+			since = time.Time{}
+		case since.IsZero():
+			since = time.Now()
+		}
+		if tmp != before && time.Since(since) >= in.debounce {
+			handle(tmp)
+			before = tmp
+			since = time.Time{}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type rpioOutput struct {
+	pin rpio.Pin
+}
+
+func (out rpioOutput) Write(level Level) {
+	if level {
+		out.pin.Write(rpio.High)
+	} else {
+		out.pin.Write(rpio.Low)
+	}
+}
+
+// rpioPWMOutput is a pin driven by a hardware PWM channel
+type rpioPWMOutput struct {
+	pin rpio.Pin
+}
+
+func (out rpioPWMOutput) Write(level Level) {
+	if level {
+		out.Duty(100)
+	} else {
+		out.Duty(0)
+	}
+}
+
+func (out rpioPWMOutput) Duty(percent int) {
+	out.pin.DutyCycle(uint32(min(max(percent, 0), 100)*pwmCycle/100), pwmCycle)
+}
@@ -0,0 +1,183 @@
+package gpio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sim is an in-memory backend whose inputs are driven by test code, a file or a socket
+type Sim struct {
+	mu       sync.Mutex
+	levels   map[int]Level
+	watchers map[int][]chan Level
+}
+
+// NewSim returns a simulated backend with every pin low
+func NewSim() *Sim {
+	return &Sim{
+		levels:   make(map[int]Level),
+		watchers: make(map[int][]chan Level),
+	}
+}
+
+func (s *Sim) Input(pin int, pull Pull, debounce time.Duration) (Input, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.levels[pin]; !ok {
+		// An open switch reads as the level it is pulled to
+		s.levels[pin] = pull == PullUp
+	}
+	return simPin{sim: s, pin: pin}, nil
+}
+
+func (s *Sim) Output(pin int) (Output, error) {
+	return simPin{sim: s, pin: pin}, nil
+}
+
+func (s *Sim) Close() error {
+	return nil
+}
+
+// Set drives pin to level, notifying watchers if it changed
+func (s *Sim) Set(pin int, level Level) {
+	s.mu.Lock()
+	if before, ok := s.levels[pin]; ok && before == level {
+		s.mu.Unlock()
+		return
+	}
+	s.levels[pin] = level
+	watchers := append([]chan Level(nil), s.watchers[pin]...)
+	s.mu.Unlock()
+
+	for _, w := range watchers {
+		w <- level
+	}
+}
+
+// Watched returns whether pin is being watched, so that test code can wait for the watchers to start
+func (s *Sim) Watched(pin int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.watchers[pin]) > 0
+}
+
+// Level returns the level of pin, as written to an output or set on an input
+func (s *Sim) Level(pin int) Level {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.levels[pin]
+}
+
+// Serve sets pins from lines like "17 high" or "17 0" read from r until it ends
+func (s *Sim) Serve(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pin, level, err := parseSimLine(text)
+		if err != nil {
+			log.Printf("Invalid simulated pin line %q: %v", text, err)
+			continue
+		}
+		s.Set(pin, level)
+	}
+	return scanner.Err()
+}
+
+// ListenAndServe accepts connections on addr, "unix:/path" or "host:port", and serves each of them
+func (s *Sim) ListenAndServe(addr string) error {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.Serve(conn); err != nil {
+				log.Printf("Simulated pin connection failed: %v", err)
+			}
+		}()
+	}
+}
+
+func (s *Sim) watch(pin int) (chan Level, func()) {
+	ch := make(chan Level, 16)
+	s.mu.Lock()
+	s.watchers[pin] = append(s.watchers[pin], ch)
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ws := s.watchers[pin]
+		for i, w := range ws {
+			if w == ch {
+				s.watchers[pin] = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func parseSimLine(text string) (int, Level, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return 0, Low, fmt.Errorf("want \"<pin> <level>\"")
+	}
+	pin, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, Low, err
+	}
+	switch strings.ToLower(fields[1]) {
+	case "1", "high", "on":
+		return pin, High, nil
+	case "0", "low", "off":
+		return pin, Low, nil
+	}
+	return 0, Low, fmt.Errorf("unknown level %s", fields[1])
+}
+
+// simPin is an input or output of Sim
+type simPin struct {
+	sim *Sim
+	pin int
+}
+
+func (p simPin) Read() Level {
+	return p.sim.Level(p.pin)
+}
+
+func (p simPin) Write(level Level) {
+	p.sim.Set(p.pin, level)
+}
+
+func (p simPin) Watch(ctx context.Context, handle func(Level)) error {
+	ch, stop := p.sim.watch(p.pin)
+	defer stop()
+	for {
+		select {
+		case level := <-ch:
+			handle(level)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
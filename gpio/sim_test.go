@@ -0,0 +1,80 @@
+package gpio
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimWatch(t *testing.T) {
+	sim := NewSim()
+	in, err := sim.Input(17, PullUp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Read() != High {
+		t.Errorf("Expected pulled up input to read HIGH")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	levels := make(chan Level, 4)
+	done := make(chan error)
+	go func() {
+		done <- in.Watch(ctx, func(l Level) { levels <- l })
+	}()
+	// Wait for the watcher to register
+	waitWatching(sim, 17)
+
+	sim.Set(17, Low)
+	sim.Set(17, Low) // unchanged, not reported
+	sim.Set(17, High)
+	for _, want := range []Level{Low, High} {
+		select {
+		case got := <-levels:
+			if got != want {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %v to be reported", want)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected Watch to stop with context.Canceled, got %v", err)
+	}
+}
+
+func TestSimServe(t *testing.T) {
+	sim := NewSim()
+	out, _ := sim.Output(4)
+	out.Write(High)
+	if sim.Level(4) != High {
+		t.Errorf("Expected output to be HIGH")
+	}
+
+	err := sim.Serve(strings.NewReader("# comment\n17 high\n4 0\nbroken\n22 on\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pin, want := range map[int]Level{17: High, 4: Low, 22: High} {
+		if got := sim.Level(pin); got != want {
+			t.Errorf("Expected pin %d to be %v, got %v", pin, want, got)
+		}
+	}
+}
+
+// waitWatching waits until pin has a watcher
+func waitWatching(sim *Sim, pin int) {
+	for i := 0; i < 1000; i++ {
+		sim.mu.Lock()
+		n := len(sim.watchers[pin])
+		sim.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,14 @@
+package controlremo
+
+// StatusLED selects the patterns shown by the status LED of an appliance, one of
+// "off", "solid", "blink", "fast" or "pulse"; "none" keeps showing the power state
+type StatusLED struct {
+	// Pending is shown while a command is in flight, "blink" if empty
+	Pending string `yaml:"Pending"`
+	// Error is shown for a few seconds after a command failed, "fast" if empty
+	Error string `yaml:"Error"`
+	// Unreachable is shown while MQTT, control-remo or the Remo API is unreachable, "pulse" if empty
+	Unreachable string `yaml:"Unreachable"`
+	// Dim shows the brightness of a light by PWM instead of lighting fully
+	Dim bool `yaml:"Dim"`
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cormoran/natureremo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// staleIntervals is the number of update intervals after which an appliance missing from the updates is dropped
+const staleIntervals = 3
+
+// RateLimitInfo is the rate limit reported by the remo API with a response
+type RateLimitInfo struct {
+	Limit     int64
+	Remaining int64
+	Reset     int64 // unix time
+}
+
+var (
+	appliancePowerState = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "appliance", "power_state"),
+		"Whether the appliance is powered on",
+		[]string{"id", "name", "type"}, nil,
+	)
+
+	applianceStateChanges = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "appliance", "state_changes_total"),
+		"The total number of power state changes of the appliance",
+		[]string{"id", "name", "type"}, nil,
+	)
+
+	lastUpdate = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_update_timestamp"),
+		"The time the state of the appliance was last updated",
+		[]string{"id", "name", "type"}, nil,
+	)
+
+	apiRateLimitLimit = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "api", "rate_limit_limit"),
+		"The rate limit of the remo API reported with the last response",
+		nil, nil,
+	)
+
+	apiRateLimitRemaining = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "api", "rate_limit_remaining"),
+		"The remaining requests to the remo API reported with the last response",
+		nil, nil,
+	)
+
+	apiRateLimitReset = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "api", "rate_limit_reset"),
+		"The time the rate limit of the remo API resets, reported with the last response",
+		nil, nil,
+	)
+)
+
+// Collector collects the states of the appliances and the requests to the remo API as they happen,
+// unlike the Exporter which queries the API on every scrape
+type Collector struct {
+	// interval is how often every appliance is expected to be updated, appliances are never dropped if 0
+	interval time.Duration
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	mu         sync.Mutex
+	appliances map[string]*applianceMetrics // by ID
+	rateLimit  *RateLimitInfo
+}
+
+type applianceMetrics struct {
+	name, typ string
+	on        bool
+	changes   int
+	updated   time.Time
+}
+
+// NewCollector returns a collector of appliances updated every interval, recording the requests of client,
+// if set, through a Transport
+func NewCollector(client *natureremo.Client, interval time.Duration) *Collector {
+	c := &Collector{
+		interval: interval,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "requests_total",
+			Help:      "The total number of requests to the remo API labeled by API and response code",
+		}, []string{"api", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "request_duration_seconds",
+			Help:      "The latency of requests to the remo API",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"api"}),
+		appliances: make(map[string]*applianceMetrics),
+	}
+	if client != nil {
+		hc := &http.Client{}
+		if client.HTTPClient != nil {
+			*hc = *client.HTTPClient
+		}
+		hc.Transport = &Transport{Base: hc.Transport, Collector: c}
+		client.HTTPClient = hc
+	}
+	return c
+}
+
+// UpdateApplianceState records the power state of an appliance, counting changes
+func (c *Collector) UpdateApplianceState(id, name, typ string, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.appliances[id]
+	if !ok {
+		a = &applianceMetrics{on: on}
+		c.appliances[id] = a
+	}
+	if a.on != on {
+		a.changes++
+	}
+	a.name, a.typ, a.on = name, typ, on
+	a.updated = time.Now()
+}
+
+// UpdateAPIMetrics records a request to the remo API with its status code, 0 if there was no response,
+// and the rate limit reported with it, if any
+func (c *Collector) UpdateAPIMetrics(api string, code int, seconds float64, rateLimit *RateLimitInfo) {
+	c.requests.WithLabelValues(api, codeLabel(code)).Inc()
+	c.duration.WithLabelValues(api).Observe(seconds)
+	if rateLimit == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rl := *rateLimit
+	c.rateLimit = &rl
+}
+
+func codeLabel(code int) string {
+	if code == 0 {
+		return "error"
+	}
+	return strconv.Itoa(code)
+}
+
+// Describe is to describe the metrics for Prometheus
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- appliancePowerState
+	ch <- applianceStateChanges
+	ch <- lastUpdate
+	ch <- apiRateLimitLimit
+	ch <- apiRateLimitRemaining
+	ch <- apiRateLimitReset
+	c.requests.Describe(ch)
+	c.duration.Describe(ch)
+}
+
+// Collect reports the recorded states and requests, dropping appliances which stopped being updated
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, a := range c.appliances {
+		if c.interval > 0 && now.Sub(a.updated) > staleIntervals*c.interval {
+			delete(c.appliances, id)
+			continue
+		}
+		on := 0.0
+		if a.on {
+			on = 1
+		}
+		ch <- prometheus.MustNewConstMetric(appliancePowerState, prometheus.GaugeValue, on, id, a.name, a.typ)
+		ch <- prometheus.MustNewConstMetric(applianceStateChanges, prometheus.CounterValue, float64(a.changes), id, a.name, a.typ)
+		ch <- prometheus.MustNewConstMetric(lastUpdate, prometheus.GaugeValue, float64(a.updated.Unix()), id, a.name, a.typ)
+	}
+
+	// Parsed by the transport, the client updates its LastRateLimit without a lock
+	if rl := c.rateLimit; rl != nil {
+		ch <- prometheus.MustNewConstMetric(apiRateLimitLimit, prometheus.GaugeValue, float64(rl.Limit))
+		ch <- prometheus.MustNewConstMetric(apiRateLimitRemaining, prometheus.GaugeValue, float64(rl.Remaining))
+		ch <- prometheus.MustNewConstMetric(apiRateLimitReset, prometheus.GaugeValue, float64(rl.Reset))
+	}
+	c.requests.Collect(ch)
+	c.duration.Collect(ch)
+}
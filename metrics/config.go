@@ -6,4 +6,10 @@ type Config struct {
 	ListenPort               string
 	CacheInvalidationSeconds int
 	MetricsPath              string
+	// Scheduler runs the requests of scrapes, they go straight to the client if nil
+	Scheduler Scheduler
+	// Tariffs price the consumption of smart meters by time of day
+	Tariffs []Tariff
+	// OnEnergySummary is called with the consumption of every completed day and month
+	OnEnergySummary func(EnergySummary)
 }
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Periods of energy summaries
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+var (
+	energyKWh = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "energy", "kwh_total"),
+		"The cumulative electric energy in normal direction in kWh",
+		[]string{"name", "id"}, nil,
+	)
+
+	energyCost = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "energy", "cost_total"),
+		"The cost of the electric energy consumed since the exporter started, by tariff band; the consumption between two readings is priced by the band of the later one",
+		[]string{"name", "id", "band"}, nil,
+	)
+)
+
+// Tariff is a time-of-use band of the electricity price
+type Tariff struct {
+	Band string
+	// From and To are local times like "22:00", a band spans midnight if To is before From
+	// and applies all day if both are empty; the first band applying wins
+	From  string
+	To    string
+	Price float64 // per kWh
+}
+
+// applies reports whether the band applies at t, never if its times are invalid
+func (t Tariff) applies(at time.Time) bool {
+	if t.From == "" && t.To == "" {
+		return true
+	}
+	from, ok := minuteOfDay(t.From)
+	if !ok {
+		return false
+	}
+	to, ok := minuteOfDay(t.To)
+	if !ok {
+		return false
+	}
+	m := at.Hour()*60 + at.Minute()
+	if from <= to {
+		return from <= m && m < to
+	}
+	return m >= from || m < to
+}
+
+func minuteOfDay(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// EnergySummary is the consumption of a smart meter in a day or month; the consumption between
+// two readings counts for the day of the later one
+type EnergySummary struct {
+	ID     string
+	Name   string
+	Period string // PeriodDaily or PeriodMonthly
+	Start  time.Time
+	KWh    float64
+	Cost   map[string]float64 // by tariff band
+}
+
+// Energy computes the consumption and its cost from the cumulative readings of smart meters
+type Energy struct {
+	tariffs   []Tariff
+	onSummary func(EnergySummary)
+
+	mu     sync.Mutex
+	meters map[string]*energyMeter // by device ID
+}
+
+type energyMeter struct {
+	name    string
+	lastRaw int
+	kwh     float64
+	cost    map[string]float64
+	day     EnergySummary
+	month   EnergySummary
+}
+
+// NewEnergy returns an energy computation pricing by tariffs and calling onSummary, if set,
+// with every day and month completed while it runs
+func NewEnergy(tariffs []Tariff, onSummary func(EnergySummary)) *Energy {
+	return &Energy{tariffs: tariffs, onSummary: onSummary, meters: make(map[string]*energyMeter)}
+}
+
+// Update accounts for a reading of a smart meter taken at
+func (e *Energy) Update(id, name string, info *EnergyInfo, at time.Time) {
+	perUnit := info.EnergyUnit
+	if info.Coefficient > 0 {
+		perUnit *= float64(info.Coefficient)
+	}
+
+	e.mu.Lock()
+	m, ok := e.meters[id]
+	if !ok {
+		// Start from the meter reading, the cost from zero
+		m = &energyMeter{
+			name:    name,
+			lastRaw: info.NormalEnergy,
+			kwh:     float64(info.NormalEnergy) * perUnit,
+			cost:    make(map[string]float64),
+			day:     newSummary(id, name, PeriodDaily, at),
+			month:   newSummary(id, name, PeriodMonthly, at),
+		}
+		e.meters[id] = m
+		e.mu.Unlock()
+		return
+	}
+
+	var completed []EnergySummary
+	if start := periodStart(PeriodDaily, at); start.After(m.day.Start) {
+		completed = append(completed, m.day)
+		m.day = newSummary(id, name, PeriodDaily, at)
+	}
+	if start := periodStart(PeriodMonthly, at); start.After(m.month.Start) {
+		completed = append(completed, m.month)
+		m.month = newSummary(id, name, PeriodMonthly, at)
+	}
+
+	delta := info.NormalEnergy - m.lastRaw
+	if delta < 0 && wrapped(m.lastRaw, info.NormalEnergy, info.EffectiveDigits) {
+		delta += int(math.Pow10(info.EffectiveDigits))
+	}
+	if delta > 0 {
+		kwh := float64(delta) * perUnit
+		m.kwh += kwh
+		m.day.KWh += kwh
+		m.month.KWh += kwh
+		if t, ok := e.tariff(at); ok {
+			m.cost[t.Band] += kwh * t.Price
+			m.day.Cost[t.Band] += kwh * t.Price
+			m.month.Cost[t.Band] += kwh * t.Price
+		}
+	}
+	m.name = name
+	m.lastRaw = info.NormalEnergy
+	e.mu.Unlock()
+
+	if e.onSummary != nil {
+		for _, s := range completed {
+			e.onSummary(s)
+		}
+	}
+}
+
+// wrapped reports whether a meter went from last to raw by wrapping around at its number of digits,
+// rather than by a glitch or a reset which restart the consumption from raw
+func wrapped(last, raw, digits int) bool {
+	if digits <= 0 {
+		return false
+	}
+	limit := int(math.Pow10(digits))
+	return last >= limit*9/10 && raw < limit/10
+}
+
+// tariff returns the band applying at t
+func (e *Energy) tariff(at time.Time) (Tariff, bool) {
+	for _, t := range e.tariffs {
+		if t.applies(at) {
+			return t, true
+		}
+	}
+	return Tariff{}, false
+}
+
+func newSummary(id, name, period string, at time.Time) EnergySummary {
+	return EnergySummary{ID: id, Name: name, Period: period, Start: periodStart(period, at), Cost: make(map[string]float64)}
+}
+
+func periodStart(period string, at time.Time) time.Time {
+	if period == PeriodMonthly {
+		return time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location())
+	}
+	return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+}
+
+// Describe is to describe the metrics for Prometheus
+func (e *Energy) Describe(ch chan<- *prometheus.Desc) {
+	ch <- energyKWh
+	ch <- energyCost
+}
+
+// Collect reports the consumption and cost of every smart meter
+func (e *Energy) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, m := range e.meters {
+		ch <- prometheus.MustNewConstMetric(energyKWh, prometheus.CounterValue, m.kwh, m.name, id)
+		seen := make(map[string]bool)
+		for _, t := range e.tariffs {
+			// A band may have several times of day
+			if seen[t.Band] {
+				continue
+			}
+			seen[t.Band] = true
+			ch <- prometheus.MustNewConstMetric(energyCost, prometheus.CounterValue, m.cost[t.Band], m.name, id, t.Band)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEnergy(t *testing.T) {
+	tariffs := []Tariff{
+		{Band: "night", From: "22:00", To: "07:00", Price: 20},
+		{Band: "day", Price: 30},
+	}
+	var summaries []EnergySummary
+	e := NewEnergy(tariffs, func(s EnergySummary) { summaries = append(summaries, s) })
+
+	// A five digit meter counting 0.1 kWh
+	reading := func(raw int, at time.Time) {
+		e.Update("meter", "Home", &EnergyInfo{NormalEnergy: raw, Coefficient: 1, EnergyUnit: 0.1, EffectiveDigits: 5}, at)
+	}
+	day := time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)
+	reading(99990, day.Add(12*time.Hour))
+	reading(99995, day.Add(13*time.Hour))             // 0.5 kWh by day
+	reading(10, day.Add(23*time.Hour))                // wrapped, 1.5 kWh by night
+	reading(20, day.Add(24*time.Hour+30*time.Minute)) // 1 kWh by night on the next day and month
+
+	expected := `
+# HELP remo_energy_cost_total The cost of the electric energy consumed since the exporter started, by tariff band; the consumption between two readings is priced by the band of the later one
+# TYPE remo_energy_cost_total counter
+remo_energy_cost_total{band="day",id="meter",name="Home"} 15
+remo_energy_cost_total{band="night",id="meter",name="Home"} 50
+# HELP remo_energy_kwh_total The cumulative electric energy in normal direction in kWh
+# TYPE remo_energy_kwh_total counter
+remo_energy_kwh_total{id="meter",name="Home"} 10002
+`
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if len(summaries) != 2 {
+		t.Fatalf("summaries = %+v, want the day and the month", summaries)
+	}
+	for i, period := range []string{PeriodDaily, PeriodMonthly} {
+		s := summaries[i]
+		if s.Period != period || math.Abs(s.KWh-2) > 1e-9 || math.Abs(s.Cost["night"]-30) > 1e-9 {
+			t.Errorf("%s summary = %+v, want 2 kWh costing 30 by night", period, s)
+		}
+	}
+}
+
+func TestEnergyMeterReset(t *testing.T) {
+	e := NewEnergy([]Tariff{{Band: "day", Price: 30}}, nil)
+	reading := func(raw int, at time.Time) {
+		e.Update("meter", "Home", &EnergyInfo{NormalEnergy: raw, Coefficient: 1, EnergyUnit: 0.1, EffectiveDigits: 5}, at)
+	}
+	at := time.Date(2024, 1, 31, 12, 0, 0, 0, time.Local)
+	reading(50000, at)
+	reading(50010, at.Add(time.Minute)) // 1 kWh
+	reading(20, at.Add(2*time.Minute))  // reset, not a wraparound
+	reading(30, at.Add(3*time.Minute))  // 1 kWh
+
+	if got := e.meters["meter"].cost["day"]; math.Abs(got-60) > 1e-9 {
+		t.Errorf("cost = %v, want 60 for 2 kWh", got)
+	}
+}
@@ -3,11 +3,12 @@ package metrics
 import (
 	"context"
 	"fmt"
+	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cormoran/natureremo"
-	"github.com/eivy/control-remo-from-pi/mqtt"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -77,47 +78,147 @@ var (
 		[]string{"name", "id"}, nil,
 	)
 
-	rateLimitLimit = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "x_rate_limit_limit"),
-		"The rate limit for the remo API",
+	snapshotAge = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "snapshot_age_seconds"),
+		"The time since the metrics served were fetched from the remo API",
 		nil, nil,
 	)
 
-	rateLimitReset = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "x_rate_limit_reset"),
-		"The time in which the rate limit for the remo API will be reset",
+	refreshErrors = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "refresh_errors_total"),
+		"The total number of failed refreshes of the metrics from the remo API",
 		nil, nil,
 	)
 
-	rateLimitRemaining = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "x_rate_limit_remaining"),
-		"The remaining number of request for the remo API",
+	refreshSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "last_refresh_success"),
+		"Whether the last refresh of the metrics from the remo API succeeded",
 		nil, nil,
 	)
+)
 
-	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "http_requests_total",
-		Help:      "The total number of requests labeled by response code",
-	},
-		[]string{"code", "api"},
-	)
+// Keys of the background requests of refreshes, shared with polling so that the scheduler coalesces them
+const (
+	RequestKeyDevices    = "devices"
+	RequestKeyAppliances = "appliances"
 )
 
-// Exporter collects ECS clusters metrics
+// refreshTimeout limits the requests of a refresh waiting in the scheduler
+const refreshTimeout = 30 * time.Second
+
+// defaultRefreshInterval is used when Config.CacheInvalidationSeconds is not set
+const defaultRefreshInterval = 60 * time.Second
+
+// Scheduler runs background requests to the remo API, sharing the rate limit with commands
+type Scheduler interface {
+	Background(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, error)
+}
+
+// Exporter collects the metrics of the remo devices from a snapshot refreshed in the background,
+// so that scrapes neither spend the request quota nor wait for the API
 type Exporter struct {
-	client     *natureremo.Client // Custom ECS client to get information from the clusters
-	mqttClient *mqtt.Client
+	client    *natureremo.Client
+	scheduler Scheduler
+	interval  time.Duration
+	energy    *Energy
+
+	mu          sync.Mutex
+	snapshot    *snapshot
+	errors      int
+	lastSuccess bool
+}
+
+// snapshot is what a refresh fetched from the remo API
+type snapshot struct {
+	devices    []*natureremo.Device
+	appliances []*natureremo.Appliance
+	taken      time.Time
 }
 
-// NewExporter returns an initialized exporter
-func NewExporter(config *Config, client *natureremo.Client, mqttClient *mqtt.Client) (*Exporter, error) {
+// NewExporter returns an exporter refreshing every Config.CacheInvalidationSeconds once Run
+func NewExporter(config *Config, client *natureremo.Client) (*Exporter, error) {
+	interval := time.Duration(config.CacheInvalidationSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
 	return &Exporter{
-		client:     client,
-		mqttClient: mqttClient,
+		client:    client,
+		scheduler: config.Scheduler,
+		interval:  interval,
+		energy:    NewEnergy(config.Tariffs, config.OnEnergySummary),
 	}, nil
 }
 
+// Run refreshes the snapshot right away and then every interval until ctx is done
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		err := e.refresh(ctx)
+		e.mu.Lock()
+		e.lastSuccess = err == nil
+		if err != nil {
+			e.errors++
+		}
+		e.mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to refresh metrics: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh fetches the devices and appliances, keeping the last snapshot if either fails
+func (e *Exporter) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	v, err := e.background(ctx, RequestKeyDevices, func(ctx context.Context) (any, error) {
+		return e.client.DeviceService.GetAll(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("fetching devices failed: %v", err)
+	}
+	devices, _ := v.([]*natureremo.Device)
+
+	v, err = e.background(ctx, RequestKeyAppliances, func(ctx context.Context) (any, error) {
+		return e.client.ApplianceService.GetAll(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("fetching appliances failed: %v", err)
+	}
+	appliances, _ := v.([]*natureremo.Appliance)
+
+	snap := &snapshot{devices: devices, appliances: appliances, taken: time.Now()}
+	e.mu.Lock()
+	e.snapshot = snap
+	e.mu.Unlock()
+
+	for _, sm := range getSmartMeters(appliances) {
+		info, err := energyInfo(sm)
+		if err != nil {
+			continue
+		}
+		at := info.UpdatedAt
+		if at.IsZero() {
+			at = time.Now()
+		}
+		e.energy.Update(sm.Device.ID, sm.Device.Name, info, at.Local())
+	}
+	return nil
+}
+
+// background runs a request of a refresh on the scheduler if there is one
+func (e *Exporter) background(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, error) {
+	if e.scheduler == nil {
+		return fn(ctx)
+	}
+	return e.scheduler.Background(ctx, key, fn)
+}
+
 // Describe is to describe the metrics for Prometheus
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- temperature
@@ -130,49 +231,40 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- electricEnergyUnit
 	ch <- electricEnergyDigits
 	ch <- measuredInstantaneousEnergy
-	ch <- rateLimitLimit
-	ch <- rateLimitReset
-	ch <- rateLimitRemaining
-	httpRequestsTotal.Describe(ch)
+	ch <- snapshotAge
+	ch <- refreshErrors
+	ch <- refreshSuccess
+	e.energy.Describe(ch)
 }
 
-// Collect collects data to be consumed by prometheus
+// Collect reports the metrics of the last snapshot and how fresh it is
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.Background()
-	devices, err := e.client.DeviceService.GetAll(ctx)
-	if err != nil {
-		fmt.Printf("Fetching device stats failed: %v", err)
-		return
+	e.mu.Lock()
+	snap := e.snapshot
+	failures := e.errors
+	success := 0.0
+	if e.lastSuccess {
+		success = 1
 	}
+	e.mu.Unlock()
 
-	appliances, err := e.client.ApplianceService.GetAll(ctx)
-	if err != nil {
-		fmt.Printf("Fetching appliances stats failed: %v", err)
+	ch <- prometheus.MustNewConstMetric(refreshErrors, prometheus.CounterValue, float64(failures))
+	ch <- prometheus.MustNewConstMetric(refreshSuccess, prometheus.GaugeValue, success)
+	if snap == nil {
+		// Nothing fetched yet
 		return
 	}
+	ch <- prometheus.MustNewConstMetric(snapshotAge, prometheus.GaugeValue, time.Since(snap.taken).Seconds())
 
-	err = e.processMetrics(devices, appliances, ch)
+	err := e.processMetrics(snap, ch)
 	if err != nil {
 		fmt.Printf("Processing the metrics failed: %v", err)
 		return
 	}
-
-	for _, a := range appliances {
-		if a.Type == natureremo.ApplianceTypeLight {
-			e.mqttClient.PublishStatus(mqtt.Status{
-				ApplianceID:   a.ID,
-				ApplianceName: a.Nickname,
-				Type:          string(a.Type),
-				PowerState:    a.Light.State.Power == "on",
-				Timestamp:     time.Now(),
-			})
-		}
-	}
-
 }
 
-func (e *Exporter) processMetrics(devices []*natureremo.Device, appliances []*natureremo.Appliance, ch chan<- prometheus.Metric) error {
-	for _, d := range devices {
+func (e *Exporter) processMetrics(snap *snapshot, ch chan<- prometheus.Metric) error {
+	for _, d := range snap.devices {
 		if d.NewestEvents == nil {
 			continue
 		}
@@ -190,7 +282,7 @@ func (e *Exporter) processMetrics(devices []*natureremo.Device, appliances []*na
 		}
 	}
 
-	sms := getSmartMeters(appliances)
+	sms := getSmartMeters(snap.appliances)
 	for _, sm := range sms {
 		info, err := energyInfo(sm)
 		if err != nil {
@@ -205,11 +297,7 @@ func (e *Exporter) processMetrics(devices []*natureremo.Device, appliances []*na
 		ch <- prometheus.MustNewConstMetric(measuredInstantaneousEnergy, prometheus.GaugeValue, float64(info.MeasuredInstantaneous), sm.Device.Name, sm.Device.ID)
 	}
 
-	ch <- prometheus.MustNewConstMetric(rateLimitLimit, prometheus.GaugeValue, float64(e.client.LastRateLimit.Limit))
-	ch <- prometheus.MustNewConstMetric(rateLimitRemaining, prometheus.GaugeValue, float64(e.client.LastRateLimit.Remaining))
-	ch <- prometheus.MustNewConstMetric(rateLimitReset, prometheus.GaugeValue, float64(e.client.LastRateLimit.Reset.Unix()))
-
-	httpRequestsTotal.Collect(ch)
+	e.energy.Collect(ch)
 
 	return nil
 }
@@ -231,6 +319,7 @@ type EnergyInfo struct {
 	EnergyUnit            float64
 	EffectiveDigits       int
 	MeasuredInstantaneous int
+	UpdatedAt             time.Time // when the meter reported NormalEnergy
 }
 
 func energyInfo(sm *natureremo.Appliance) (*EnergyInfo, error) {
@@ -242,6 +331,7 @@ func energyInfo(sm *natureremo.Appliance) (*EnergyInfo, error) {
 	for _, p := range sm.SmartMeter.Properties {
 		switch p.Epc {
 		case natureremo.EPCNormalDirectionCumulativeElectricEnergy:
+			info.UpdatedAt = p.UpdatedAt
 			info.NormalEnergy, err = strconv.Atoi(p.Value)
 			if err != nil {
 				return nil, err
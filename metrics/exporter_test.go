@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cormoran/natureremo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExporterServesSnapshot(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Rate-Limit-Limit", "30")
+		w.Header().Set("X-Rate-Limit-Remaining", "29")
+		w.Header().Set("X-Rate-Limit-Reset", "1700000000")
+		switch r.URL.Path {
+		case "/1/devices":
+			w.Write([]byte(`[{"id":"remo","name":"Living","newest_events":{"te":{"val":21.5}}}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer api.Close()
+
+	client := natureremo.NewClient("test-token")
+	client.BaseURL = api.URL + "/1"
+	e, err := NewExporter(&Config{}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	for range 3 {
+		if _, err := registry.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("requests = %d, scrapes must not query the API", n)
+	}
+
+	failing.Store(true)
+	if err := e.refresh(context.Background()); err == nil {
+		t.Fatal("refresh succeeded against a failing API")
+	}
+	expected := `
+# HELP remo_temperature The temperature of the remo device
+# TYPE remo_temperature gauge
+remo_temperature{id="remo",name="Living"} 21.5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "remo_temperature"); err != nil {
+		t.Errorf("last snapshot not kept: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cormoran/natureremo"
+)
+
+// idCollections are the path segments of the remo API followed by the ID of a resource
+var idCollections = map[string]bool{"appliances": true, "devices": true, "signals": true}
+
+// Transport records every request to the remo API in the collector and a log line,
+// labeled by its endpoint with the IDs of resources elided
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+	// Collector receives the code, latency and rate limit of every request, if set
+	Collector *Collector
+	// Logger logs every request, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+// RoundTrip sends the request with the base transport and records it
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	api := Endpoint(req)
+	code := 0
+	var rateLimit *RateLimitInfo
+	if resp != nil {
+		code = resp.StatusCode
+		if rl, err := natureremo.RateLimitFromHeader(resp.Header); err == nil {
+			rateLimit = &RateLimitInfo{Limit: rl.Limit, Remaining: rl.Remaining, Reset: rl.Reset.Unix()}
+		}
+	}
+	if t.Collector != nil {
+		t.Collector.UpdateAPIMetrics(api, code, elapsed.Seconds(), rateLimit)
+	}
+
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []any{"api", api, "code", code, "duration", elapsed}
+	if rateLimit != nil {
+		attrs = append(attrs, "rate_limit_remaining", rateLimit.Remaining)
+	}
+	switch {
+	case err != nil:
+		logger.Warn("remo API request failed", append(attrs, "error", err)...)
+	case code >= http.StatusBadRequest:
+		logger.Warn("remo API request rejected", attrs...)
+	default:
+		logger.Debug("remo API request", attrs...)
+	}
+	return resp, err
+}
+
+// Endpoint returns the method and path of a request with resource IDs replaced by ":id",
+// e.g. "POST /1/appliances/:id/light"
+func Endpoint(req *http.Request) string {
+	segments := strings.Split(req.URL.Path, "/")
+	for i := 1; i < len(segments); i++ {
+		if idCollections[segments[i-1]] && segments[i] != "" {
+			segments[i] = ":id"
+		}
+	}
+	return req.Method + " " + strings.Join(segments, "/")
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cormoran/natureremo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/1/appliances", "GET /1/appliances"},
+		{"POST", "/1/appliances/abc-123/light", "POST /1/appliances/:id/light"},
+		{"POST", "/1/signals/abc-123/send", "POST /1/signals/:id/send"},
+		{"GET", "/1/users/me", "GET /1/users/me"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := Endpoint(req); got != tt.want {
+			t.Errorf("Endpoint(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTransportRecordsRequests(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Limit", "30")
+		w.Header().Set("X-Rate-Limit-Remaining", "12")
+		w.Header().Set("X-Rate-Limit-Reset", "1700000000")
+		w.Write([]byte(`{"power":"on"}`))
+	}))
+	defer api.Close()
+
+	client := natureremo.NewClient("test-token")
+	client.BaseURL = api.URL + "/1"
+	collector := NewCollector(client, 0)
+	client.HTTPClient.Transport.(*Transport).Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := client.ApplianceService.SendLightSignal(context.Background(), &natureremo.Appliance{ID: "light-1"}, "on"); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(collector.requests.WithLabelValues("POST /1/appliances/:id/light", "200")); got != 1 {
+		t.Errorf("collector requests = %v, want 1", got)
+	}
+	if collector.rateLimit == nil || collector.rateLimit.Remaining != 12 {
+		t.Errorf("rate limit = %+v, want 12 remaining", collector.rateLimit)
+	}
+}
@@ -5,9 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/cormoran/natureremo"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
@@ -15,24 +16,59 @@ import (
 type Config struct {
 	Broker   string
 	Port     int
+	Scheme   string // "tcp" (default), "ssl", "ws" or "wss"
 	Username string
 	Password string
 	ClientID string
+	// TLS settings, used with secure schemes
+	CAFile             string // PEM bundle of the private CA, system roots if empty
+	CertFile           string // client certificate for mutual TLS
+	KeyFile            string
+	InsecureSkipVerify bool
+	// Availability sets a last will of "offline" on the availability topic and publishes "online" on connect
+	Availability bool
+	// TopicPrefix is the root of every topic, "remo" if empty
+	TopicPrefix string
+	// Topics maps appliance IDs to the names used in their topics instead of the ID
+	Topics map[string]string
+	// ConnectRetry keeps connecting in the background when the broker is unreachable at startup
+	// instead of failing Connect
+	ConnectRetry bool
+	// OnConnect is called after every connection to the broker, e.g. to publish what changed while disconnected
+	OnConnect func()
 }
 
+const (
+	PayloadOnline  = "online"
+	PayloadOffline = "offline"
+)
+
+// connectTimeout is how long Connect waits for the broker with ConnectRetry before going on in the background
+const connectTimeout = 5 * time.Second
+
 // Client wraps MQTT client functionality
 type Client struct {
 	client      mqtt.Client
 	config      Config
 	commandChan chan Command
 	statusChan  chan Status
+	topicIDs    map[string]string // topic name to appliance ID
+
+	mu            sync.Mutex
+	subscriptions map[string]mqtt.MessageHandler // by topic filter, renewed on every connection
 }
 
 // Command represents a remote control command
 type Command struct {
 	ApplianceID string `json:"appliance_id"`
 	Button      string `json:"button"`
-	Type        string `json:"type"` // "light", "tv", "ir", "local"
+	Type        string `json:"type"`                 // "light", "tv", "ir", "local", "aircon"
+	RequestID   string `json:"request_id,omitempty"` // echoed in the Result of the command
+	// Air conditioner settings, empty fields keep their current value
+	Temperature   string `json:"temperature,omitempty"`
+	OperationMode string `json:"operation_mode,omitempty"`
+	AirVolume     string `json:"air_volume,omitempty"`
+	AirDirection  string `json:"air_direction,omitempty"`
 }
 
 // Status represents appliance status change
@@ -42,11 +78,55 @@ type Status struct {
 	Type          string    `json:"type"`
 	PowerState    bool      `json:"power_state"`
 	Timestamp     time.Time `json:"timestamp"`
+	// Settings is set for air conditioners only
+	Settings *natureremo.AirConSettings `json:"settings,omitempty"`
+	// Brightness in percent is set for lights reporting one
+	Brightness *int `json:"brightness,omitempty"`
+}
+
+// Result is the outcome of a command
+type Result struct {
+	RequestID   string    `json:"request_id,omitempty"`
+	ApplianceID string    `json:"appliance_id"`
+	Button      string    `json:"button"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+	Status      *Status   `json:"status,omitempty"` // resulting state of the appliance
+	Latency     float64   `json:"latency_seconds"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Gate represents the state of the condition pin gating an appliance
+type Gate struct {
+	ApplianceID string    `json:"appliance_id"`
+	Inhibited   bool      `json:"inhibited"`
+	Suppressed  string    `json:"suppressed,omitempty"` // button which was ignored
+	Remapped    string    `json:"remapped,omitempty"`   // button which was sent instead
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Breaker represents the state of the circuit breaker of a Remo device
+type Breaker struct {
+	Device    string    `json:"device"`
+	State     string    `json:"state"` // "closed", "half-open" or "open"
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EnergySummary is the consumption of a smart meter in a completed day or month
+type EnergySummary struct {
+	DeviceID   string             `json:"device_id"`
+	DeviceName string             `json:"device_name"`
+	Period     string             `json:"period"` // "daily" or "monthly"
+	Start      time.Time          `json:"start"`
+	KWh        float64            `json:"kwh"`
+	Cost       map[string]float64 `json:"cost,omitempty"` // by tariff band
+	Timestamp  time.Time          `json:"timestamp"`
 }
 
 // CommandHandler defines the interface for handling MQTT commands
 type CommandHandler interface {
-	HandleCommand(cmd Command) error
+	// HandleCommand returns the resulting status of the appliance, if known
+	HandleCommand(cmd Command) (*Status, error)
 }
 
 // StatusHandler defines the interface for handling MQTT commands
@@ -54,10 +134,35 @@ type StatusHandler interface {
 	HandleStatus(cmd Status) error
 }
 
+// ResultHandler defines the interface for handling command results
+type ResultHandler interface {
+	HandleResult(result Result) error
+}
+
+// AvailabilityHandler defines the interface for handling availability changes of control-remo
+type AvailabilityHandler interface {
+	HandleAvailability(online bool) error
+}
+
+// APIStatusHandler defines the interface for handling whether control-remo reaches the Remo API
+type APIStatusHandler interface {
+	HandleAPIStatus(reachable bool) error
+}
+
 // NewClient creates a new MQTT client
-func NewClient(config Config) *Client {
+func NewClient(config Config) (*Client, error) {
+	if config.Scheme == "" {
+		config.Scheme = "tcp"
+	}
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", config.Broker, config.Port))
+	opts.AddBroker(fmt.Sprintf("%s://%s:%d", config.Scheme, config.Broker, config.Port))
+	if config.secure() {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
 	opts.SetClientID(config.ClientID)
 	opts.SetUsername(config.Username)
 	opts.SetPassword(config.Password)
@@ -66,38 +171,66 @@ func NewClient(config Config) *Client {
 	opts.SetKeepAlive(30 * time.Second)
 	opts.SetAutoReconnect(true)
 	opts.SetMaxReconnectInterval(10 * time.Second)
+	opts.SetConnectRetry(config.ConnectRetry)
+	opts.SetConnectRetryInterval(10 * time.Second)
+
+	topicIDs := make(map[string]string)
+	for id, name := range config.Topics {
+		topicIDs[name] = id
+	}
+
+	c := &Client{
+		config:        config,
+		commandChan:   make(chan Command, 100),
+		statusChan:    make(chan Status, 100),
+		topicIDs:      topicIDs,
+		subscriptions: make(map[string]mqtt.MessageHandler),
+	}
 
 	// Connection lost handler
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
 		log.Printf("MQTT connection lost: %v", err)
 	})
 
+	if config.Availability {
+		// The broker publishes the will when the connection is lost without a disconnect
+		opts.SetWill(config.availabilityTopic(), PayloadOffline, 1, true)
+	}
+
 	// On connect handler
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
 		log.Println("MQTT connected successfully")
+		if config.Availability {
+			client.Publish(config.availabilityTopic(), 1, true, PayloadOnline)
+		}
+		// The broker forgets subscriptions of clean sessions, waiting for them must not block the handler
+		go c.resubscribe()
 	})
 
-	client := mqtt.NewClient(opts)
-
-	return &Client{
-		client:      client,
-		config:      config,
-		commandChan: make(chan Command, 100),
-		statusChan:  make(chan Status, 100),
-	}
+	c.client = mqtt.NewClient(opts)
+	return c, nil
 }
 
 // Connect establishes connection to MQTT broker
 func (c *Client) Connect() error {
-	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
+	token := c.client.Connect()
+	if c.config.ConnectRetry && !token.WaitTimeout(connectTimeout) {
+		log.Printf("MQTT broker at %s://%s:%d unreachable, connecting in the background", c.config.Scheme, c.config.Broker, c.config.Port)
+		return nil
+	}
+	if token.Wait() && token.Error() != nil {
 		return fmt.Errorf("failed to connect to MQTT broker: %v", token.Error())
 	}
-	log.Printf("Connected to MQTT broker at %s:%d", c.config.Broker, c.config.Port)
+	log.Printf("Connected to MQTT broker at %s://%s:%d", c.config.Scheme, c.config.Broker, c.config.Port)
 	return nil
 }
 
 // Disconnect closes the connection to MQTT broker
 func (c *Client) Disconnect() {
+	if c.config.Availability {
+		// A clean disconnect does not trigger the will
+		c.client.Publish(c.AvailabilityTopic(), 1, true, PayloadOffline).WaitTimeout(time.Second)
+	}
 	c.client.Disconnect(250)
 	close(c.commandChan)
 	close(c.statusChan)
@@ -105,22 +238,26 @@ func (c *Client) Disconnect() {
 
 // SubscribeCommands subscribes to command topics and starts processing
 func (c *Client) SubscribeCommands(ctx context.Context, handler CommandHandler) error {
-	// Subscribe to command topic: remo/command/{appliance_id}
-	commandTopic := "remo/command/+"
+	// Subscribe to command topic: {prefix}/command/{appliance_id or topic name}
+	commandTopic := c.wildcard(topicCommand)
 
-	token := c.client.Subscribe(commandTopic, 1, func(client mqtt.Client, msg mqtt.Message) {
+	err := c.subscribe(commandTopic, func(client mqtt.Client, msg mqtt.Message) {
 		// Extract appliance ID from topic
-		parts := strings.Split(msg.Topic(), "/")
-		if len(parts) != 3 {
+		applianceID, ok := c.applianceID(topicCommand, msg.Topic())
+		if !ok {
 			log.Printf("Invalid command topic format: %s", msg.Topic())
 			return
 		}
-		applianceID := parts[2]
 
 		// Parse command payload
 		var payload struct {
-			Button string `json:"button"`
-			Type   string `json:"type,omitempty"`
+			RequestID     string `json:"request_id,omitempty"`
+			Button        string `json:"button"`
+			Type          string `json:"type,omitempty"`
+			Temperature   string `json:"temperature,omitempty"`
+			OperationMode string `json:"operation_mode,omitempty"`
+			AirVolume     string `json:"air_volume,omitempty"`
+			AirDirection  string `json:"air_direction,omitempty"`
 		}
 
 		if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
@@ -129,9 +266,14 @@ func (c *Client) SubscribeCommands(ctx context.Context, handler CommandHandler)
 		}
 
 		command := Command{
-			ApplianceID: applianceID,
-			Button:      payload.Button,
-			Type:        payload.Type,
+			ApplianceID:   applianceID,
+			RequestID:     payload.RequestID,
+			Button:        payload.Button,
+			Type:          payload.Type,
+			Temperature:   payload.Temperature,
+			OperationMode: payload.OperationMode,
+			AirVolume:     payload.AirVolume,
+			AirDirection:  payload.AirDirection,
 		}
 
 		// Send to command channel for processing
@@ -142,8 +284,8 @@ func (c *Client) SubscribeCommands(ctx context.Context, handler CommandHandler)
 		}
 	})
 
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to subscribe to commands: %v", token.Error())
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to commands: %v", err)
 	}
 
 	log.Printf("Subscribed to MQTT command topic: %s", commandTopic)
@@ -156,22 +298,24 @@ func (c *Client) SubscribeCommands(ctx context.Context, handler CommandHandler)
 
 // SubscribeStatus subscribes to status topics and starts processing
 func (c *Client) SubscribeStatus(ctx context.Context, handler StatusHandler) error {
-	// Subscribe to command topic: remo/command/{appliance_id}
-	statusTopic := "remo/status/+"
+	// Subscribe to status topic: {prefix}/status/{appliance_id or topic name}
+	statusTopic := c.wildcard(topicStatus)
 
-	token := c.client.Subscribe(statusTopic, 1, func(client mqtt.Client, msg mqtt.Message) {
+	err := c.subscribe(statusTopic, func(client mqtt.Client, msg mqtt.Message) {
 		// Extract appliance ID from topic
-		parts := strings.Split(msg.Topic(), "/")
-		if len(parts) != 3 {
-			log.Printf("Invalid command topic format: %s", msg.Topic())
+		applianceID, ok := c.applianceID(topicStatus, msg.Topic())
+		if !ok {
+			log.Printf("Invalid status topic format: %s", msg.Topic())
 			return
 		}
-		applianceID := parts[2]
 
 		// Parse command payload
 		var payload struct {
-			PowerState bool   `json:"power_state"`
-			Type       string `json:"type,omitempty"`
+			Name       string                     `json:"appliance_name,omitempty"`
+			PowerState bool                       `json:"power_state"`
+			Type       string                     `json:"type,omitempty"`
+			Settings   *natureremo.AirConSettings `json:"settings,omitempty"`
+			Brightness *int                       `json:"brightness,omitempty"`
 		}
 
 		if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
@@ -180,9 +324,12 @@ func (c *Client) SubscribeStatus(ctx context.Context, handler StatusHandler) err
 		}
 
 		status := Status{
-			ApplianceID: applianceID,
-			PowerState:  payload.PowerState,
-			Type:        payload.Type,
+			ApplianceID:   applianceID,
+			ApplianceName: payload.Name,
+			PowerState:    payload.PowerState,
+			Type:          payload.Type,
+			Settings:      payload.Settings,
+			Brightness:    payload.Brightness,
 		}
 
 		// Send to command channel for processing
@@ -193,8 +340,8 @@ func (c *Client) SubscribeStatus(ctx context.Context, handler StatusHandler) err
 		}
 	})
 
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to subscribe to commands: %v", token.Error())
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to commands: %v", err)
 	}
 
 	log.Printf("Subscribed to MQTT command topic: %s", statusTopic)
@@ -205,16 +352,96 @@ func (c *Client) SubscribeStatus(ctx context.Context, handler StatusHandler) err
 	return nil
 }
 
+// SubscribeAvailability subscribes to the availability topic of control-remo
+func (c *Client) SubscribeAvailability(handler AvailabilityHandler) error {
+	err := c.subscribe(c.AvailabilityTopic(), func(client mqtt.Client, msg mqtt.Message) {
+		online := string(msg.Payload()) == PayloadOnline
+		if err := handler.HandleAvailability(online); err != nil {
+			log.Printf("Failed to handle availability: %v", err)
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to availability: %v", err)
+	}
+
+	log.Printf("Subscribed to MQTT availability topic: %s", c.AvailabilityTopic())
+	return nil
+}
+
+// SubscribeAPIStatus subscribes to the Remo API status published by control-remo
+func (c *Client) SubscribeAPIStatus(handler APIStatusHandler) error {
+	err := c.subscribe(c.config.apiTopic(), func(client mqtt.Client, msg mqtt.Message) {
+		reachable := string(msg.Payload()) == PayloadOnline
+		if err := handler.HandleAPIStatus(reachable); err != nil {
+			log.Printf("Failed to handle API status: %v", err)
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to API status: %v", err)
+	}
+
+	log.Printf("Subscribed to MQTT API status topic: %s", c.config.apiTopic())
+	return nil
+}
+
+// SubscribeResults subscribes to command results
+func (c *Client) SubscribeResults(handler ResultHandler) error {
+	resultTopic := c.wildcard(topicResult)
+
+	err := c.subscribe(resultTopic, func(client mqtt.Client, msg mqtt.Message) {
+		applianceID, ok := c.applianceID(topicResult, msg.Topic())
+		if !ok {
+			log.Printf("Invalid result topic format: %s", msg.Topic())
+			return
+		}
+
+		var result Result
+		if err := json.Unmarshal(msg.Payload(), &result); err != nil {
+			log.Printf("Failed to parse result payload: %v", err)
+			return
+		}
+		result.ApplianceID = applianceID
+
+		if err := handler.HandleResult(result); err != nil {
+			log.Printf("Failed to handle result for %s: %v", applianceID, err)
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to results: %v", err)
+	}
+
+	log.Printf("Subscribed to MQTT result topic: %s", resultTopic)
+	return nil
+}
+
 // processCommands handles incoming commands from MQTT
 func (c *Client) processCommands(ctx context.Context, handler CommandHandler) {
 	for {
 		select {
 		case cmd := <-c.commandChan:
-			if err := handler.HandleCommand(cmd); err != nil {
+			start := time.Now()
+			status, err := handler.HandleCommand(cmd)
+			result := Result{
+				RequestID:   cmd.RequestID,
+				ApplianceID: cmd.ApplianceID,
+				Button:      cmd.Button,
+				Success:     err == nil,
+				Status:      status,
+				Latency:     time.Since(start).Seconds(),
+				Timestamp:   time.Now(),
+			}
+			if err != nil {
 				log.Printf("Failed to handle command for %s: %v", cmd.ApplianceID, err)
+				result.Error = err.Error()
 			} else {
 				log.Printf("Successfully handled command: %s -> %s", cmd.ApplianceID, cmd.Button)
 			}
+			if err := c.PublishResult(result); err != nil {
+				log.Printf("Failed to publish result for %s: %v", cmd.ApplianceID, err)
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -239,7 +466,7 @@ func (c *Client) processStatus(ctx context.Context, handler StatusHandler) {
 
 // PublishCommand publishes command
 func (c *Client) PublishCommand(cmd Command) error {
-	topic := fmt.Sprintf("remo/command/%s", cmd.ApplianceID)
+	topic := c.topic(topicCommand, cmd.ApplianceID)
 
 	payload, err := json.Marshal(cmd)
 	if err != nil {
@@ -251,20 +478,20 @@ func (c *Client) PublishCommand(cmd Command) error {
 		return fmt.Errorf("failed to publish status: %v", token.Error())
 	}
 
-	log.Printf("Published command for %s: button=%t", cmd.ApplianceID, cmd.Button)
+	log.Printf("Published command for %s: button=%s", cmd.ApplianceID, cmd.Button)
 	return nil
 }
 
-// PublishStatus publishes appliance status changes
+// PublishStatus publishes appliance status changes, retained so that late subscribers get the current state
 func (c *Client) PublishStatus(status Status) error {
-	topic := fmt.Sprintf("remo/status/%s", status.ApplianceID)
+	topic := c.topic(topicStatus, status.ApplianceID)
 
 	payload, err := json.Marshal(status)
 	if err != nil {
 		return fmt.Errorf("failed to marshal status: %v", err)
 	}
 
-	token := c.client.Publish(topic, 1, false, payload)
+	token := c.client.Publish(topic, 1, true, payload)
 	if token.Wait() && token.Error() != nil {
 		return fmt.Errorf("failed to publish status: %v", token.Error())
 	}
@@ -273,34 +500,126 @@ func (c *Client) PublishStatus(status Status) error {
 	return nil
 }
 
-// PublishStatusAsync publishes status changes asynchronously
-func (c *Client) PublishStatusAsync(status Status) {
-	select {
-	case c.statusChan <- status:
-	default:
-		log.Printf("Status channel full, dropping status for %s", status.ApplianceID)
+// PublishResult publishes the outcome of a command
+func (c *Client) PublishResult(result Result) error {
+	topic := c.topic(topicResult, result.ApplianceID)
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+
+	token := c.client.Publish(topic, 1, false, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish result: %v", token.Error())
+	}
+
+	log.Printf("Published result for %s: success=%t", result.ApplianceID, result.Success)
+	return nil
+}
+
+// PublishGate publishes condition pin state and the presses it affected
+func (c *Client) PublishGate(gate Gate) error {
+	topic := c.topic(topicGate, gate.ApplianceID)
+
+	payload, err := json.Marshal(gate)
+	if err != nil {
+		return fmt.Errorf("failed to marshal gate: %v", err)
+	}
+
+	token := c.client.Publish(topic, 1, false, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish gate: %v", token.Error())
 	}
+
+	log.Printf("Published gate for %s: inhibited=%t", gate.ApplianceID, gate.Inhibited)
+	return nil
 }
 
-// StartStatusPublisher starts the background status publisher
-func (c *Client) StartStatusPublisher(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case status := <-c.statusChan:
-				if err := c.PublishStatus(status); err != nil {
-					log.Printf("Failed to publish status: %v", err)
-				}
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+// PublishAPIStatus publishes whether the Remo API is reachable, retained like the availability
+func (c *Client) PublishAPIStatus(reachable bool) error {
+	payload := PayloadOffline
+	if reachable {
+		payload = PayloadOnline
+	}
+
+	token := c.client.Publish(c.config.apiTopic(), 1, true, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish API status: %v", token.Error())
+	}
+
+	log.Printf("Published API status: reachable=%t", reachable)
+	return nil
+}
+
+// PublishBreaker publishes the state of the circuit breaker of a device, retained like the API status
+func (c *Client) PublishBreaker(breaker Breaker) error {
+	payload, err := json.Marshal(breaker)
+	if err != nil {
+		return fmt.Errorf("failed to marshal breaker: %v", err)
+	}
+
+	token := c.client.Publish(c.config.breakerTopic(breaker.Device), 1, true, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish breaker: %v", token.Error())
+	}
+
+	log.Printf("Published breaker for %s: state=%s", breaker.Device, breaker.State)
+	return nil
+}
+
+// PublishEnergySummary publishes the consumption of a smart meter in a day or month,
+// retained so that the last completed period is always available
+func (c *Client) PublishEnergySummary(summary EnergySummary) error {
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal energy summary: %v", err)
+	}
+
+	token := c.client.Publish(c.config.energyTopic(summary.DeviceID, summary.Period), 1, true, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish energy summary: %v", token.Error())
+	}
+
+	log.Printf("Published %s energy summary for %s: %.3f kWh", summary.Period, summary.DeviceName, summary.KWh)
+	return nil
 }
 
-// IsConnected checks if the client is connected
+// IsConnected checks if the client is connected to the broker right now, not while reconnecting
 func (c *Client) IsConnected() bool {
-	return c.client.IsConnected()
+	return c.client.IsConnectionOpen()
+}
+
+// subscribe subscribes to the topic now if connected and again on every connection
+func (c *Client) subscribe(topic string, handler mqtt.MessageHandler) error {
+	c.mu.Lock()
+	c.subscriptions[topic] = handler
+	c.mu.Unlock()
+	if !c.client.IsConnectionOpen() {
+		log.Printf("Not connected, subscribing to %s once connected", topic)
+		return nil
+	}
+	token := c.client.Subscribe(topic, 1, handler)
+	token.Wait()
+	return token.Error()
+}
+
+// resubscribe renews all subscriptions and calls the OnConnect hook
+func (c *Client) resubscribe() {
+	c.mu.Lock()
+	subscriptions := make(map[string]mqtt.MessageHandler, len(c.subscriptions))
+	for topic, handler := range c.subscriptions {
+		subscriptions[topic] = handler
+	}
+	c.mu.Unlock()
+	for topic, handler := range subscriptions {
+		if token := c.client.Subscribe(topic, 1, handler); token.Wait() && token.Error() != nil {
+			log.Printf("Failed to resubscribe to %s: %v", topic, token.Error())
+		}
+	}
+	if c.config.OnConnect != nil {
+		c.config.OnConnect()
+	}
 }
 
 // GetConfig returns the current MQTT configuration
@@ -0,0 +1,91 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ConfigFromEnv reads the broker settings from MQTT_* environment variables
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Broker:   os.Getenv("MQTT_BROKER"),
+		Scheme:   os.Getenv("MQTT_SCHEME"),
+		Username: os.Getenv("MQTT_USERNAME"),
+		Password: os.Getenv("MQTT_PASSWORD"),
+		ClientID: os.Getenv("MQTT_CLIENT_ID"),
+		CAFile:   os.Getenv("MQTT_CA_FILE"),
+		CertFile: os.Getenv("MQTT_CERT_FILE"),
+		KeyFile:  os.Getenv("MQTT_KEY_FILE"),
+
+		TopicPrefix: os.Getenv("MQTT_TOPIC_PREFIX"),
+	}
+	if config.Broker == "" {
+		return config, errors.New("set MQTT_BROKER")
+	}
+	if config.Scheme == "" {
+		config.Scheme = "tcp"
+	}
+
+	portStr := os.Getenv("MQTT_PORT")
+	if portStr == "" {
+		portStr = "1883"
+		if config.secure() {
+			portStr = "8883"
+		}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return config, fmt.Errorf("invalid MQTT_PORT: %v", err)
+	}
+	config.Port = port
+
+	if v := os.Getenv("MQTT_INSECURE_SKIP_VERIFY"); v != "" {
+		config.InsecureSkipVerify, err = strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid MQTT_INSECURE_SKIP_VERIFY: %v", err)
+		}
+	}
+	return config, nil
+}
+
+// secure reports whether the scheme connects over TLS
+func (c Config) secure() bool {
+	switch c.Scheme {
+	case "ssl", "tls", "mqtts", "wss":
+		return true
+	}
+	return false
+}
+
+// tlsConfig builds the TLS settings from the CA bundle and client certificate files
+func (c Config) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,144 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Component is a Home Assistant MQTT integration component
+type Component string
+
+const (
+	ComponentLight   Component = "light"
+	ComponentSwitch  Component = "switch"
+	ComponentClimate Component = "climate"
+	ComponentSensor  Component = "sensor"
+)
+
+// Entity describes an appliance or a Remo sensor to announce to Home Assistant
+type Entity struct {
+	ID        string // appliance ID, or device ID for sensors
+	Name      string
+	Component Component
+	// Sensor is the key of the value in Sensor payloads, e.g. "temperature"
+	Sensor string
+	Unit   string
+}
+
+// Sensor represents the latest sensor values of a Remo device
+type Sensor struct {
+	DeviceID     string    `json:"device_id"`
+	DeviceName   string    `json:"device_name"`
+	Temperature  *float64  `json:"temperature,omitempty"`
+	Humidity     *float64  `json:"humidity,omitempty"`
+	Illumination *float64  `json:"illumination,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// sensorDeviceClasses maps Sensor payload keys to Home Assistant device classes
+var sensorDeviceClasses = map[string]string{
+	"temperature":  "temperature",
+	"humidity":     "humidity",
+	"illumination": "illuminance",
+}
+
+// PublishDiscovery publishes retained Home Assistant discovery payloads for entities under prefix
+func (c *Client) PublishDiscovery(prefix string, entities []Entity) error {
+	for _, e := range entities {
+		objectID := e.ID
+		if e.Component == ComponentSensor {
+			objectID = fmt.Sprintf("%s_%s", e.ID, e.Sensor)
+		}
+		topic := fmt.Sprintf("%s/%s/remo/%s/config", prefix, e.Component, objectID)
+
+		payload, err := json.Marshal(c.discoveryPayload(e, objectID))
+		if err != nil {
+			return fmt.Errorf("failed to marshal discovery for %s: %v", e.ID, err)
+		}
+
+		token := c.client.Publish(topic, 1, true, payload)
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to publish discovery: %v", token.Error())
+		}
+	}
+
+	log.Printf("Published Home Assistant discovery for %d entities", len(entities))
+	return nil
+}
+
+// PublishSensor publishes the latest sensor values of a Remo device
+func (c *Client) PublishSensor(sensor Sensor) error {
+	topic := c.topic(topicSensor, sensor.DeviceID)
+
+	payload, err := json.Marshal(sensor)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sensor: %v", err)
+	}
+
+	token := c.client.Publish(topic, 1, false, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish sensor: %v", token.Error())
+	}
+	return nil
+}
+
+func (c *Client) discoveryPayload(e Entity, objectID string) map[string]interface{} {
+	commandTopic := c.topic(topicCommand, e.ID)
+	stateTopic := c.topic(topicStatus, e.ID)
+	p := map[string]interface{}{
+		"unique_id":          "remo_" + objectID,
+		"name":               e.Name,
+		"availability_topic": c.AvailabilityTopic(),
+		"device": map[string]interface{}{
+			"identifiers":  []string{"remo_" + e.ID},
+			"name":         e.Name,
+			"manufacturer": "Nature",
+		},
+	}
+
+	switch e.Component {
+	case ComponentLight:
+		p["schema"] = "template"
+		p["command_topic"] = commandTopic
+		p["state_topic"] = stateTopic
+		p["command_on_template"] = `{"button":"on"}`
+		p["command_off_template"] = `{"button":"off"}`
+		p["state_template"] = "{{ 'on' if value_json.power_state else 'off' }}"
+	case ComponentSwitch:
+		p["command_topic"] = commandTopic
+		p["state_topic"] = stateTopic
+		p["payload_on"] = `{"button":"on"}`
+		p["payload_off"] = `{"button":"off"}`
+		p["state_on"] = "ON"
+		p["state_off"] = "OFF"
+		p["value_template"] = "{{ 'ON' if value_json.power_state else 'OFF' }}"
+	case ComponentClimate:
+		// Remo calls heat "warm" and fan only "blow"
+		p["modes"] = []string{"off", "auto", "cool", "heat", "dry", "fan_only"}
+		p["mode_command_topic"] = commandTopic
+		p["mode_command_template"] = `{% if value == 'off' %}{"button":"off"}{% else %}{"button":"on","operation_mode":"{{ {'heat':'warm','fan_only':'blow'}.get(value, value) }}"}{% endif %}`
+		p["mode_state_topic"] = stateTopic
+		p["mode_state_template"] = `{% if not value_json.power_state %}off{% else %}{{ {'warm':'heat','blow':'fan_only'}.get(value_json.settings.mode, value_json.settings.mode) }}{% endif %}`
+		p["temperature_command_topic"] = commandTopic
+		p["temperature_command_template"] = `{"temperature":"{{ value }}"}`
+		p["temperature_state_topic"] = stateTopic
+		p["temperature_state_template"] = "{{ value_json.settings.temp }}"
+		p["fan_modes"] = []string{"auto", "1", "2", "3", "4", "5"}
+		p["fan_mode_command_topic"] = commandTopic
+		p["fan_mode_command_template"] = `{"air_volume":"{{ value }}"}`
+		p["fan_mode_state_topic"] = stateTopic
+		p["fan_mode_state_template"] = "{{ value_json.settings.vol }}"
+	case ComponentSensor:
+		p["name"] = fmt.Sprintf("%s %s", e.Name, e.Sensor)
+		p["state_topic"] = c.topic(topicSensor, e.ID)
+		p["value_template"] = fmt.Sprintf("{{ value_json.%s }}", e.Sensor)
+		p["device_class"] = sensorDeviceClasses[e.Sensor]
+		p["state_class"] = "measurement"
+		if e.Unit != "" {
+			p["unit_of_measurement"] = e.Unit
+		}
+	}
+	return p
+}
@@ -0,0 +1,76 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultTopicPrefix is the root of every topic unless Config.TopicPrefix is set
+const DefaultTopicPrefix = "remo"
+
+// Topic kinds below the prefix, followed by the appliance topic name
+const (
+	topicCommand = "command"
+	topicStatus  = "status"
+	topicResult  = "result"
+	topicGate    = "gate"
+	topicSensor  = "sensor"
+)
+
+func (c Config) topicPrefix() string {
+	if c.TopicPrefix == "" {
+		return DefaultTopicPrefix
+	}
+	return strings.TrimSuffix(c.TopicPrefix, "/")
+}
+
+// availabilityTopic tells whether control-remo is connected to the broker
+func (c Config) availabilityTopic() string {
+	return c.topicPrefix() + "/availability"
+}
+
+// apiTopic tells whether control-remo reaches the Remo API
+func (c Config) apiTopic() string {
+	return c.topicPrefix() + "/api"
+}
+
+// breakerTopic tells the state of the circuit breaker of a Remo device
+func (c Config) breakerTopic(device string) string {
+	return c.topicPrefix() + "/breaker/" + device
+}
+
+// energyTopic carries the energy summaries of a smart meter for period, "daily" or "monthly"
+func (c Config) energyTopic(deviceID, period string) string {
+	return c.topicPrefix() + "/energy/" + deviceID + "/" + period
+}
+
+// AvailabilityTopic returns the topic control-remo publishes its availability on
+func (c *Client) AvailabilityTopic() string {
+	return c.config.availabilityTopic()
+}
+
+// topic returns the topic of kind for the appliance, using its topic name if one is configured
+func (c *Client) topic(kind, applianceID string) string {
+	name := applianceID
+	if t, ok := c.config.Topics[applianceID]; ok && t != "" {
+		name = t
+	}
+	return fmt.Sprintf("%s/%s/%s", c.config.topicPrefix(), kind, name)
+}
+
+// wildcard returns the topic filter matching kind for every appliance
+func (c *Client) wildcard(kind string) string {
+	return fmt.Sprintf("%s/%s/+", c.config.topicPrefix(), kind)
+}
+
+// applianceID extracts the appliance ID from a topic of kind, mapping topic names back to IDs
+func (c *Client) applianceID(kind, topic string) (string, bool) {
+	name, ok := strings.CutPrefix(topic, fmt.Sprintf("%s/%s/", c.config.topicPrefix(), kind))
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	if id, ok := c.topicIDs[name]; ok {
+		return id, true
+	}
+	return name, true
+}
@@ -0,0 +1,89 @@
+package controlremo
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cormoran/natureremo"
+)
+
+// Defaults of RetryPolicy
+const (
+	DefaultRetryAttempts   = 3
+	DefaultRetryBackoff    = 500 * time.Millisecond
+	DefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// RetryPolicy retries sends failing transiently with jittered exponential backoff
+type RetryPolicy struct {
+	// Attempts is the number of tries including the first one, 1 disables retries
+	Attempts int `yaml:"Attempts"`
+	// Backoff is the wait before the first retry, doubled for every further one up to MaxBackoff
+	Backoff    time.Duration `yaml:"Backoff"`
+	MaxBackoff time.Duration `yaml:"MaxBackoff"`
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultRetryAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	return p
+}
+
+// delay returns the wait before the retry following attempt, a random duration between half and all of the backoff
+// so that panels retrying together spread out
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// Transient reports whether a send failing with err may succeed when retried:
+// timeouts, network errors and server errors of the Remo API, but not rejected requests or anything else
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrBreakerOpen) {
+		return false
+	}
+	var apiErr *natureremo.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Idempotent reports whether sending the button to the appliance again after a failure that may have
+// reached it is harmless: switching the power of an appliance that knows its state, but not a toggle
+// or an IR signal, which would switch it back
+func Idempotent(a ApplianceData, button string) bool {
+	switch a.Type {
+	case ApplianceTypeLight, ApplianceTypeLocal, ApplianceTypeAirCon:
+		return button == "on" || button == "off"
+	}
+	return false
+}
+
+// sleep waits for d, reporting false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,271 @@
+package controlremo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cormoran/natureremo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority orders the requests to the Remo Cloud API
+type Priority int
+
+const (
+	// PriorityBackground is polling and scrapes, delayed while few requests remain
+	PriorityBackground Priority = iota
+	// PriorityCommand is commands of users, run before any background work
+	PriorityCommand
+)
+
+func (p Priority) String() string {
+	if p == PriorityCommand {
+		return "command"
+	}
+	return "background"
+}
+
+// DefaultLowRemaining is the number of remaining requests below which background work waits for the rate limit reset
+const DefaultLowRemaining = 30
+
+var (
+	schedulerQueueDepth = prometheus.NewDesc(
+		prometheus.BuildFQName("remo", "scheduler", "queue_depth"),
+		"The number of requests to the remo API waiting in the scheduler",
+		[]string{"priority"}, nil,
+	)
+
+	schedulerCoalesced = prometheus.NewDesc(
+		prometheus.BuildFQName("remo", "scheduler", "coalesced_total"),
+		"The total number of requests answered by an equal request already waiting",
+		nil, nil,
+	)
+
+	schedulerDelayed = prometheus.NewDesc(
+		prometheus.BuildFQName("remo", "scheduler", "backoff"),
+		"Whether background requests wait for the rate limit reset",
+		nil, nil,
+	)
+)
+
+// Scheduler runs the requests to the Remo Cloud API one at a time, commands first,
+// so that the request quota is shared sensibly between commands, polling and scrapes
+type Scheduler struct {
+	client *natureremo.Client
+	// LowRemaining delays background requests until the rate limit resets while fewer requests remain
+	LowRemaining int64
+
+	mu        sync.Mutex
+	queues    [2][]*job // by priority
+	keys      map[string]*job
+	coalesced int
+	backoff   bool
+	wake      chan struct{}
+}
+
+// job is a queued request, waited on by every caller with the same key; it runs with a context
+// of its own, canceled once the last waiter gives up
+type job struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	priority Priority
+	key      string
+	fn       func(context.Context) (any, error)
+	waiters  int
+	started  bool
+	done     chan struct{}
+	result   any
+	err      error
+}
+
+// NewScheduler returns a scheduler in front of client, watching its rate limit
+func NewScheduler(client *natureremo.Client) *Scheduler {
+	return &Scheduler{
+		client:       client,
+		LowRemaining: DefaultLowRemaining,
+		keys:         make(map[string]*job),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Do queues fn and waits for its result; a waiting request with the same non-empty key is run only once
+func (s *Scheduler) Do(ctx context.Context, priority Priority, key string, fn func(context.Context) (any, error)) (any, error) {
+	s.mu.Lock()
+	j, ok := s.keys[key]
+	if ok && key != "" {
+		s.coalesced++
+		if priority > j.priority {
+			// Someone is waiting for it now, move it up
+			s.remove(j)
+			j.priority = priority
+			s.queues[priority] = append(s.queues[priority], j)
+		}
+	} else {
+		// Detached from the caller so that coalesced callers outlive the first one
+		jctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		j = &job{ctx: jctx, cancel: cancel, priority: priority, key: key, fn: fn, done: make(chan struct{})}
+		s.queues[priority] = append(s.queues[priority], j)
+		if key != "" {
+			s.keys[key] = j
+		}
+	}
+	j.waiters++
+	s.mu.Unlock()
+	s.signal()
+
+	select {
+	case <-j.done:
+		return j.result, j.err
+	case <-ctx.Done():
+		s.leave(j)
+		return nil, ctx.Err()
+	}
+}
+
+// leave drops a waiter of j, dequeuing or canceling the job once nobody waits for it
+func (s *Scheduler) leave(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.waiters--
+	if j.waiters > 0 {
+		return
+	}
+	if !j.started {
+		s.remove(j)
+		if j.key != "" && s.keys[j.key] == j {
+			delete(s.keys, j.key)
+		}
+	}
+	j.cancel()
+}
+
+// Command runs a request of a user
+func (s *Scheduler) Command(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, error) {
+	return s.Do(ctx, PriorityCommand, key, fn)
+}
+
+// Background runs a polling request, coalescing it with a waiting one with the same key
+func (s *Scheduler) Background(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, error) {
+	return s.Do(ctx, PriorityBackground, key, fn)
+}
+
+// Schedule runs fn on the scheduler and returns its result typed
+func Schedule[T any](ctx context.Context, s *Scheduler, priority Priority, key string, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if s == nil {
+		return fn(ctx)
+	}
+	v, err := s.Do(ctx, priority, key, func(ctx context.Context) (any, error) {
+		return fn(ctx)
+	})
+	if err != nil {
+		return zero, err
+	}
+	t, _ := v.(T)
+	return t, nil
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run executes the queued requests until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		j, wait := s.next()
+		if j == nil {
+			var timeout <-chan time.Time
+			var t *time.Timer
+			if wait > 0 {
+				t = time.NewTimer(wait)
+				timeout = t.C
+			}
+			select {
+			case <-s.wake:
+			case <-timeout:
+			case <-ctx.Done():
+				return
+			}
+			if t != nil {
+				t.Stop()
+			}
+			continue
+		}
+		j.result, j.err = j.fn(j.ctx)
+		j.cancel()
+		close(j.done)
+	}
+}
+
+// next dequeues the request to run, or returns how long background requests have to wait
+func (s *Scheduler) next() (*job, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q := s.queues[PriorityCommand]; len(q) > 0 {
+		return s.pop(PriorityCommand), 0
+	}
+	if len(s.queues[PriorityBackground]) == 0 {
+		return nil, 0
+	}
+	if wait := s.backoffFor(); wait > 0 {
+		s.backoff = true
+		return nil, wait
+	}
+	s.backoff = false
+	return s.pop(PriorityBackground), 0
+}
+
+// backoffFor returns the time until the rate limit resets if few requests remain
+func (s *Scheduler) backoffFor() time.Duration {
+	rl := s.client.LastRateLimit
+	if rl == nil || rl.Limit == 0 || rl.Remaining >= s.LowRemaining {
+		return 0
+	}
+	return time.Until(rl.Reset)
+}
+
+func (s *Scheduler) pop(priority Priority) *job {
+	j := s.queues[priority][0]
+	s.queues[priority] = s.queues[priority][1:]
+	j.started = true
+	if j.key != "" {
+		delete(s.keys, j.key)
+	}
+	return j
+}
+
+func (s *Scheduler) remove(j *job) {
+	q := s.queues[j.priority]
+	for i := range q {
+		if q[i] == j {
+			s.queues[j.priority] = append(q[:i:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+// Describe is to describe the metrics for Prometheus
+func (s *Scheduler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- schedulerQueueDepth
+	ch <- schedulerCoalesced
+	ch <- schedulerDelayed
+}
+
+// Collect reports the queue depth by priority, the coalesced requests and whether background work is delayed
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range []Priority{PriorityBackground, PriorityCommand} {
+		ch <- prometheus.MustNewConstMetric(schedulerQueueDepth, prometheus.GaugeValue, float64(len(s.queues[p])), p.String())
+	}
+	ch <- prometheus.MustNewConstMetric(schedulerCoalesced, prometheus.CounterValue, float64(s.coalesced))
+	backoff := 0.0
+	if s.backoff {
+		backoff = 1
+	}
+	ch <- prometheus.MustNewConstMetric(schedulerDelayed, prometheus.GaugeValue, backoff)
+}
@@ -0,0 +1,121 @@
+package controlremo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cormoran/natureremo"
+)
+
+func TestSchedulerPriorityAndCoalescing(t *testing.T) {
+	s := NewScheduler(natureremo.NewClient("test-token"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return name, nil
+		}
+	}
+
+	// Queue everything before the scheduler runs
+	var wg sync.WaitGroup
+	results := make([]string, 3)
+	for i, name := range []string{"poll", "poll-again"} {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i], _ = Schedule(ctx, s, PriorityBackground, "appliances", record(name))
+		}(i, name)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[2], _ = Schedule(ctx, s, PriorityCommand, "", record("command"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	go s.Run(ctx)
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != "command" || order[1] != "poll" {
+		t.Errorf("Expected the command first and one poll, got %v", order)
+	}
+	if results[0] != "poll" || results[1] != "poll" {
+		t.Errorf("Expected both polls to get the result of the first, got %v", results)
+	}
+}
+
+func TestSchedulerCoalescedOutlivesFirstCaller(t *testing.T) {
+	s := NewScheduler(natureremo.NewClient("test-token"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	firstCtx, firstCancel := context.WithCancel(ctx)
+	first := make(chan error)
+	go func() {
+		_, err := Schedule(firstCtx, s, PriorityBackground, "appliances", func(context.Context) (string, error) { return "first", nil })
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan string)
+	go func() {
+		v, err := Schedule(ctx, s, PriorityBackground, "appliances", func(context.Context) (string, error) { return "second", nil })
+		if err != nil {
+			t.Error(err)
+		}
+		second <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The first caller gives up before the scheduler gets to the request
+	firstCancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the first caller to be canceled, got %v", err)
+	}
+	go s.Run(ctx)
+	if v := <-second; v != "first" {
+		t.Errorf("Expected the coalesced request to run for the second caller, got %q", v)
+	}
+
+	// Once every caller gave up the request is dropped
+	idle := NewScheduler(natureremo.NewClient("test-token"))
+	lateCtx, lateCancel := context.WithCancel(ctx)
+	lateCancel()
+	Schedule(lateCtx, idle, PriorityBackground, "appliances", func(context.Context) (string, error) { return "", nil })
+	if len(idle.keys) != 0 || len(idle.queues[PriorityBackground]) != 0 {
+		t.Errorf("Expected the abandoned request to be dropped, got %v", idle.keys)
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	client := natureremo.NewClient("test-token")
+	client.LastRateLimit = &natureremo.RateLimit{Limit: 30, Remaining: 1, Reset: time.Now().Add(time.Hour)}
+	s := NewScheduler(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	// Commands still run while few requests remain
+	if _, err := Schedule(ctx, s, PriorityCommand, "", func(context.Context) (bool, error) { return true, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	pollCtx, pollCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer pollCancel()
+	_, err := Schedule(pollCtx, s, PriorityBackground, "", func(context.Context) (bool, error) {
+		t.Error("Expected background work to wait for the rate limit reset")
+		return true, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the poll to time out, got %v", err)
+	}
+}
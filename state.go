@@ -0,0 +1,177 @@
+package controlremo
+
+import (
+	"sync"
+	"time"
+)
+
+// StateChange is a change of the state of an appliance
+type StateChange struct {
+	Status ApplianceStatus
+	// External is set for states reported by others, e.g. received on MQTT, which need not be published again
+	External bool
+}
+
+// StateManager owns the states and timers of appliances shared by the goroutines of control-remo,
+// persisting them in a store
+type StateManager struct {
+	store *Store
+
+	mu          sync.Mutex
+	states      map[string]ApplianceStatus
+	timers      map[string]*time.Timer
+	subscribers map[int]func(StateChange)
+	nextID      int
+}
+
+// NewStateManager returns a state manager starting from the states in the store
+func NewStateManager(store *Store) *StateManager {
+	return &StateManager{
+		store:       store,
+		states:      store.States(),
+		timers:      make(map[string]*time.Timer),
+		subscribers: make(map[int]func(StateChange)),
+	}
+}
+
+// Get returns the last known state of an appliance
+func (m *StateManager) Get(id string) (ApplianceStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.states[id]
+	return status, ok
+}
+
+// All returns the last known states by appliance ID
+func (m *StateManager) All() map[string]ApplianceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make(map[string]ApplianceStatus, len(m.states))
+	for id, status := range m.states {
+		states[id] = status
+	}
+	return states
+}
+
+// Set records the state of an appliance and tells the subscribers if it changed
+func (m *StateManager) Set(status ApplianceStatus) bool {
+	return m.set(StateChange{Status: status})
+}
+
+// SetExternal records the state of an appliance reported by others
+func (m *StateManager) SetExternal(status ApplianceStatus) bool {
+	return m.set(StateChange{Status: status, External: true})
+}
+
+func (m *StateManager) set(change StateChange) bool {
+	m.mu.Lock()
+	last, ok := m.states[change.Status.ID]
+	// Statuses from others may omit what is known already
+	if change.Status.Name == "" {
+		change.Status.Name = last.Name
+	}
+	if change.Status.Type == "" {
+		change.Status.Type = last.Type
+	}
+	m.states[change.Status.ID] = change.Status
+	changed := !ok || !last.Equal(change.Status)
+	if changed || last.Name != change.Status.Name || last.Type != change.Status.Type {
+		// Rewriting the file for every repeated state would wear out the SD card
+		m.store.SetState(change.Status)
+	}
+	subscribers := make([]func(StateChange), 0, len(m.subscribers))
+	if changed {
+		for _, fn := range m.subscribers {
+			subscribers = append(subscribers, fn)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(change)
+	}
+	return changed
+}
+
+// Subscribe calls fn with every change of a state until unsubscribe is called;
+// fn runs on the goroutine setting the state and must not block for long
+func (m *StateManager) Subscribe(fn func(StateChange)) (unsubscribe func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextID
+	m.nextID++
+	m.subscribers[id] = fn
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.subscribers, id)
+	}
+}
+
+// StartTimer calls fire after d, restarting the timer of the appliance if it is running;
+// it reports whether a running timer was restarted
+func (m *StateManager) StartTimer(id string, d time.Duration, fire func()) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, running := m.timers[id]
+	if running {
+		old.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		m.mu.Lock()
+		current := m.timers[id] == t
+		if current {
+			delete(m.timers, id)
+			m.store.ClearTimer(id)
+		}
+		m.mu.Unlock()
+		if !current {
+			// Restarted or stopped while firing
+			return
+		}
+		fire()
+	})
+	m.timers[id] = t
+	m.store.SetTimer(id, time.Now().Add(d))
+	return running
+}
+
+// TimerRunning reports whether the timer of an appliance is running
+func (m *StateManager) TimerRunning(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.timers[id]
+	return ok
+}
+
+// StopTimer stops the timer of an appliance without firing it
+func (m *StateManager) StopTimer(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.timers[id]; ok {
+		t.Stop()
+		delete(m.timers, id)
+	}
+	m.store.ClearTimer(id)
+}
+
+// Deadlines returns when the timers persisted in the store fire, including those of a previous run
+func (m *StateManager) Deadlines() map[string]time.Time {
+	return m.store.Timers()
+}
+
+// Equal reports whether the status is the same as other, ignoring the name and type
+func (s ApplianceStatus) Equal(other ApplianceStatus) bool {
+	if s.PowerOn != other.PowerOn || s.Available != other.Available {
+		return false
+	}
+	if (s.Brightness == nil) != (other.Brightness == nil) ||
+		s.Brightness != nil && *s.Brightness != *other.Brightness {
+		return false
+	}
+	if s.AirCon == nil || other.AirCon == nil {
+		return s.AirCon == other.AirCon
+	}
+	return *s.AirCon == *other.AirCon
+}
@@ -0,0 +1,147 @@
+package controlremo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestStateManager(t *testing.T) *StateManager {
+	store, err := OpenStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStateManager(store)
+}
+
+func TestStateManagerConcurrentSet(t *testing.T) {
+	m := newTestStateManager(t)
+	var changes atomic.Int64
+	unsubscribe := m.Subscribe(func(c StateChange) { changes.Add(1) })
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("appliance-%d", i%2)
+			for j := 0; j < 100; j++ {
+				m.Set(ApplianceStatus{ID: id, PowerOn: j%2 == 0})
+				m.SetExternal(ApplianceStatus{ID: id, PowerOn: j%3 == 0})
+				m.Get(id)
+				m.All()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(m.All()) != 2 {
+		t.Errorf("Expected 2 appliances, got %+v", m.All())
+	}
+	if changes.Load() == 0 {
+		t.Errorf("Expected subscribers to be told about changes")
+	}
+}
+
+func TestStateManagerSubscribe(t *testing.T) {
+	m := newTestStateManager(t)
+	var got []StateChange
+	unsubscribe := m.Subscribe(func(c StateChange) { got = append(got, c) })
+
+	m.Set(ApplianceStatus{ID: "light", PowerOn: true})
+	m.Set(ApplianceStatus{ID: "light", PowerOn: true})
+	m.SetExternal(ApplianceStatus{ID: "light", PowerOn: false})
+	unsubscribe()
+	m.Set(ApplianceStatus{ID: "light", PowerOn: true})
+
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", got)
+	}
+	if got[0].External || !got[1].External {
+		t.Errorf("Expected only the second change to be external, got %+v", got)
+	}
+}
+
+func TestStateManagerTimers(t *testing.T) {
+	m := newTestStateManager(t)
+	fired := make(chan string, 10)
+
+	if m.StartTimer("fan", 20*time.Millisecond, func() { fired <- "fan" }) {
+		t.Errorf("Expected a new timer")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.StartTimer("fan", 20*time.Millisecond, func() { fired <- "fan" })
+			m.TimerRunning("fan")
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the timer to fire")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Errorf("Expected restarted timers to fire once, fired %d more times", len(fired))
+	}
+	if m.TimerRunning("fan") || len(m.Deadlines()) != 0 {
+		t.Errorf("Expected the fired timer to be forgotten")
+	}
+
+	m.StartTimer("light", time.Hour, func() { fired <- "light" })
+	m.StopTimer("light")
+	if m.TimerRunning("light") || len(m.Deadlines()) != 0 {
+		t.Errorf("Expected the stopped timer to be forgotten")
+	}
+}
+
+func TestStateManagerSavesChangesOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewStateManager(store)
+
+	m.Set(ApplianceStatus{ID: "light", Name: "Light", PowerOn: true})
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set(ApplianceStatus{ID: "light", Name: "Light", PowerOn: true})
+	m.SetExternal(ApplianceStatus{ID: "light", Name: "Light", PowerOn: true})
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("Expected an unchanged state not to rewrite the state file")
+	}
+
+	m.Set(ApplianceStatus{ID: "light", Name: "Light", PowerOn: false})
+	if changed, _ := os.Stat(path); os.SameFile(before, changed) {
+		t.Error("Expected a changed state to be saved")
+	}
+}
+
+func TestStateManagerKeepsNameOfEcho(t *testing.T) {
+	m := newTestStateManager(t)
+	m.Set(ApplianceStatus{ID: "light", Name: "Light", Type: "light", PowerOn: true})
+	// control-remo receives its own status back without the name
+	m.SetExternal(ApplianceStatus{ID: "light", PowerOn: false})
+
+	got, _ := m.Get("light")
+	if got.Name != "Light" || got.Type != "light" || got.PowerOn {
+		t.Errorf("Expected the echo to change the power only, got %+v", got)
+	}
+}
@@ -0,0 +1,181 @@
+package controlremo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cormoran/natureremo"
+)
+
+// historySize is the number of commands kept in the history
+const historySize = 100
+
+// ApplianceStatus represents the current status of an appliance
+type ApplianceStatus struct {
+	ID        string                     `json:"id"`
+	Name      string                     `json:"name"`
+	Type      string                     `json:"type"`
+	PowerOn   bool                       `json:"power_on"`
+	Available bool                       `json:"available"`
+	AirCon    *natureremo.AirConSettings `json:"settings,omitempty"`
+	// Brightness in percent, for lights reporting one
+	Brightness *int `json:"brightness,omitempty"`
+}
+
+// CommandRecord is an executed command in the history
+type CommandRecord struct {
+	Time        time.Time `json:"time"`
+	ApplianceID string    `json:"appliance_id"`
+	Button      string    `json:"button"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Store keeps the last known states, the deadlines of running timers and the command history
+// in a JSON file so that they survive restarts
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data storeData
+}
+
+type storeData struct {
+	States  map[string]ApplianceStatus `json:"states"`
+	Timers  map[string]time.Time       `json:"timers"` // off deadlines of TIMER appliances
+	History []CommandRecord            `json:"history"`
+}
+
+// OpenStore reads the store from path, a missing file is an empty store;
+// with an empty path nothing is persisted
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: storeData{
+			States: make(map[string]ApplianceStatus),
+			Timers: make(map[string]time.Time),
+		},
+	}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %v", path, err)
+	}
+	if s.data.States == nil {
+		s.data.States = make(map[string]ApplianceStatus)
+	}
+	if s.data.Timers == nil {
+		s.data.Timers = make(map[string]time.Time)
+	}
+	return s, nil
+}
+
+// States returns the last known states by appliance ID
+func (s *Store) States() map[string]ApplianceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make(map[string]ApplianceStatus, len(s.data.States))
+	for id, st := range s.data.States {
+		states[id] = st
+	}
+	return states
+}
+
+// SetState records the last known state of an appliance
+func (s *Store) SetState(status ApplianceStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.States[status.ID] = status
+	s.save()
+}
+
+// Timers returns the off deadlines of running timers by appliance ID
+func (s *Store) Timers() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timers := make(map[string]time.Time, len(s.data.Timers))
+	for id, deadline := range s.data.Timers {
+		timers[id] = deadline
+	}
+	return timers
+}
+
+// SetTimer records when the timer of an appliance turns it off
+func (s *Store) SetTimer(applianceID string, deadline time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Timers[applianceID] = deadline
+	s.save()
+}
+
+// ClearTimer forgets the timer of an appliance
+func (s *Store) ClearTimer(applianceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Timers, applianceID)
+	s.save()
+}
+
+// AddCommand appends a command to the history, dropping the oldest beyond historySize
+func (s *Store) AddCommand(record CommandRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.History = append(s.data.History, record)
+	if n := len(s.data.History); n > historySize {
+		s.data.History = append([]CommandRecord(nil), s.data.History[n-historySize:]...)
+	}
+	s.save()
+}
+
+// History returns the recent commands, oldest first
+func (s *Store) History() []CommandRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CommandRecord(nil), s.data.History...)
+}
+
+// save writes the store to a temporary file and renames it so that a crash never leaves a partial file
+func (s *Store) save() {
+	if s.path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode state: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		log.Printf("Failed to save state: %v", err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to save state: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package controlremo
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Minute).Round(0)
+	s.SetState(ApplianceStatus{ID: "light", Name: "Light", PowerOn: true})
+	s.SetTimer("fan", deadline)
+	for i := 0; i < historySize+5; i++ {
+		s.AddCommand(CommandRecord{ApplianceID: "light", Button: "toggle"})
+	}
+
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := s.States()["light"]; !ok || !st.PowerOn {
+		t.Errorf("Expected light to be restored as on, got %+v", s.States())
+	}
+	if got := s.Timers()["fan"]; !got.Equal(deadline) {
+		t.Errorf("Expected timer deadline %v, got %v", deadline, got)
+	}
+	if n := len(s.History()); n != historySize {
+		t.Errorf("Expected %d history records, got %d", historySize, n)
+	}
+}
+
+func TestStoreMissingFile(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.States()) != 0 || len(s.Timers()) != 0 {
+		t.Errorf("Expected an empty store")
+	}
+}
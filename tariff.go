@@ -0,0 +1,33 @@
+package controlremo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Tariff is a time-of-use band of the electricity price
+type Tariff struct {
+	Band string `yaml:"Band"`
+	// From and To are local times like "22:00", a band spans midnight if To is before From
+	// and applies all day if both are empty; the first band applying wins
+	From  string  `yaml:"From"`
+	To    string  `yaml:"To"`
+	Price float64 `yaml:"Price"` // per kWh
+}
+
+// validate checks that the band is named and its times, if any, are valid
+func (t Tariff) validate() error {
+	if t.Band == "" {
+		return errors.New("missing Band")
+	}
+	if t.From == "" && t.To == "" {
+		return nil
+	}
+	for _, s := range []string{t.From, t.To} {
+		if _, err := time.Parse("15:04", s); err != nil {
+			return fmt.Errorf("invalid time %q: %v", s, err)
+		}
+	}
+	return nil
+}
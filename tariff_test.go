@@ -0,0 +1,21 @@
+package controlremo
+
+import "testing"
+
+func TestTariffValidate(t *testing.T) {
+	tests := []struct {
+		tariff  Tariff
+		wantErr bool
+	}{
+		{Tariff{Band: "day", Price: 30}, false},
+		{Tariff{Band: "night", From: "22:00", To: "07:00", Price: 20}, false},
+		{Tariff{From: "22:00", To: "07:00"}, true},
+		{Tariff{Band: "night", From: "22:00"}, true},
+		{Tariff{Band: "night", From: "10pm", To: "7am"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.tariff.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v, want error %t", tt.tariff, err, tt.wantErr)
+		}
+	}
+}
@@ -6,7 +6,7 @@ type Trigger string
 const (
 	// TOGGLE is toggle
 	TriggerTOGGLE Trigger = "TOGGLE"
-	// SYNC is syncronization
+	// SYNC makes the appliance follow the position of a latching switch
 	TriggerSYNC Trigger = "SYNC"
 	// TriggerTimer is syncronization
 	TriggerTimer Trigger = "TIMER"